@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// packageRules returns rules with the target aliases the other Go
+// files of the package of file already use: a package importing
+// k8s.io/utils/net as utilnet keeps doing so in the rewritten files.
+// The import of file itself is not counted, so that it is renamed
+// like in a single file. The rules whose target is not imported with
+// a name by the other files keep their TargetAlias. With
+// renameExistingAlias, rules is returned as is: the existing imports
+// are renamed to the TargetAlias, so the package converges on it.
+func packageRules(aliases importAliases, file string, rules []Rule) []Rule {
+	if renameExistingAlias {
+		return rules
+	}
+	prevailing := aliases.prevailing(file)
+	if len(prevailing) == 0 {
+		return rules
+	}
+	out := make([]Rule, len(rules))
+	for i, r := range rules {
+		if alias, ok := prevailing[r.TargetPath]; ok {
+			r.TargetAlias = alias
+		}
+		out[i] = r
+	}
+	return out
+}
+
+// importAliases holds the Go files of a directory importing each
+// import path under each name, by path and name. The paths imported
+// without a name are not included.
+type importAliases map[string]map[string][]string
+
+// packageAliases returns the named imports of the Go files of dir.
+func packageAliases(dir string) importAliases {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	aliases := make(importAliases)
+	fset := token.NewFileSet()
+	for _, e := range entries {
+		if !isGoFile(e) {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, e.Name()), nil, parser.ImportsOnly)
+		if err != nil {
+			continue
+		}
+		for _, s := range f.Imports {
+			if s.Name == nil || s.Name.Name == "_" || s.Name.Name == "." {
+				continue
+			}
+			path := importPath(s)
+			if aliases[path] == nil {
+				aliases[path] = make(map[string][]string)
+			}
+			aliases[path][s.Name.Name] = append(aliases[path][s.Name.Name], e.Name())
+		}
+	}
+	return aliases
+}
+
+// prevailing returns the name each import path is most often imported
+// as by the files of a, except the one named exclude. Ties go to the
+// first name in alphabetical order.
+func (a importAliases) prevailing(exclude string) map[string]string {
+	best := make(map[string]string)
+	for path, names := range a {
+		var sorted []string
+		counts := make(map[string]int)
+		for name, files := range names {
+			for _, f := range files {
+				if f != exclude {
+					counts[name]++
+				}
+			}
+			if counts[name] > 0 {
+				sorted = append(sorted, name)
+			}
+		}
+		if len(sorted) == 0 {
+			continue
+		}
+		sort.Strings(sorted)
+		b := sorted[0]
+		for _, name := range sorted[1:] {
+			if counts[name] > counts[b] {
+				b = name
+			}
+		}
+		best[path] = b
+	}
+	return best
+}
+
+// importNameWarnings returns a warning for each package of dir, told
+// apart by package clause, whose Go files import one of paths under
+// several names, like net in foo_linux.go and n in foo_windows.go: the
+// rewritten files keep them, so the migration does not fix it. The
+// warnings name dir as name.
+func importNameWarnings(dir, name string, paths []string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	want := make(map[string]bool)
+	for _, path := range paths {
+		want[path] = true
+	}
+	// files holds the files importing each path under each name, by
+	// package.
+	files := make(map[string]map[string]map[string][]string)
+	fset := token.NewFileSet()
+	for _, e := range entries {
+		if !isGoFile(e) {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, e.Name()), nil, parser.ImportsOnly)
+		if err != nil {
+			continue
+		}
+		for _, s := range f.Imports {
+			path := importPath(s)
+			if !want[path] || importName(s) == "_" {
+				continue
+			}
+			pkg := f.Name.Name
+			if files[pkg] == nil {
+				files[pkg] = make(map[string]map[string][]string)
+			}
+			if files[pkg][path] == nil {
+				files[pkg][path] = make(map[string][]string)
+			}
+			files[pkg][path][importName(s)] = append(files[pkg][path][importName(s)], e.Name())
+		}
+	}
+
+	var warnings []string
+	for pkg, byPath := range files {
+		for path, byName := range byPath {
+			if len(byName) < 2 {
+				continue
+			}
+			var names []string
+			for n, fs := range byName {
+				names = append(names, fmt.Sprintf("%s in %s", n, strings.Join(fs, ", ")))
+			}
+			sort.Strings(names)
+			warnings = append(warnings, fmt.Sprintf("%s: package %s imports %q under several names: %s", name, pkg, path, strings.Join(names, "; ")))
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
@@ -0,0 +1,1414 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestMain runs the command instead of the tests when
+// SLOPPY_NETPARSER_MAIN is set, so the tests can exercise the CLI
+// by running the test binary.
+func TestMain(m *testing.M) {
+	if os.Getenv("SLOPPY_NETPARSER_MAIN") != "" {
+		main()
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs the command with args and stdin, and returns its
+// standard output, standard error and exit code.
+func runMain(t *testing.T, stdin string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	return runMainIn(t, "", stdin, args...)
+}
+
+// runMainIn is runMain, running the command in the directory dir.
+func runMainIn(t *testing.T, dir, stdin string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	var outb, errb bytes.Buffer
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "SLOPPY_NETPARSER_MAIN=1")
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = &outb
+	cmd.Stderr = &errb
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		code = exitErr.ExitCode()
+	case err != nil:
+		t.Fatal(err)
+	}
+	return outb.String(), errb.String(), code
+}
+
+// writeFiles creates the files in a temporary directory,
+// and returns the directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+const (
+	cleanSrc = `package main
+
+import "net"
+
+func f() net.IP {
+	return nil
+}
+`
+	dirtySrc = `package main
+
+import "net"
+
+func f() net.IP {
+	return net.ParseIP("1.2.3.4")
+}
+`
+)
+
+func TestDiffExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		args []string
+		want int
+	}{
+		{"clean", cleanSrc, []string{"-diff"}, 0},
+		{"dirty", dirtySrc, []string{"-diff"}, 3},
+		{"dirty without exit code", dirtySrc, []string{"-diff", "-diff-exit-code=false"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{"a.go": tt.src})
+			stdout, stderr, code := runMain(t, "", append(tt.args, dir)...)
+			if code != tt.want {
+				t.Errorf("exit code %d, want %d\nstderr:\n%s", code, tt.want, stderr)
+			}
+			if changed := strings.Contains(stdout, "+++"); changed != (tt.src == dirtySrc) {
+				t.Errorf("unexpected diff output:\n%s", stdout)
+			}
+			b, err := os.ReadFile(filepath.Join(dir, "a.go"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.src {
+				t.Errorf("-diff modified the file")
+			}
+		})
+	}
+}
+
+func TestDumpAST(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.go": "package main\n\nimport n \"net\"\n\nvar ip = n.ParseIP(\"1.2.3.4\")\n"})
+
+	_, stderr, code := runMain(t, "", "-dump-ast", filepath.Join(dir, "a.go"))
+	if code != 0 {
+		t.Fatalf("exit code %d\nstderr:\n%s", code, stderr)
+	}
+	for _, want := range []string{"*ast.SelectorExpr", `Name: "ParseIP"`, "imports:\n\tn \"net\"\n"} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("output does not contain %q", want)
+		}
+	}
+
+	if _, _, code := runMain(t, "", "-dump-ast", dir); code != 2 {
+		t.Errorf("exit code %d for a directory, want 2", code)
+	}
+}
+
+func TestDuplicatePaths(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.go": dirtySrc})
+	path := filepath.Join(dir, "a.go")
+
+	stdout, stderr, _ := runMain(t, "", "-diff", path, path, dir, filepath.Join(dir, ".", "a.go"))
+	if n := strings.Count(stderr, "+1 ParseIP"); n != 1 {
+		t.Errorf("file reported %d times, want 1\nstderr:\n%s", n, stderr)
+	}
+	if n := strings.Count(stdout, "+++"); n != 1 {
+		t.Errorf("got %d diffs, want 1\nstdout:\n%s", n, stdout)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	broken := "package main\n\nfunc f() { net.ParseIP( }\n"
+	dir := writeFiles(t, map[string]string{"a.go": broken, "b.go": dirtySrc, "c.go": broken})
+
+	_, stderr, code := runMain(t, "", "-l", dir)
+	if code != 2 {
+		t.Errorf("exit code %d, want 2", code)
+	}
+	for _, name := range []string{"a.go", "c.go"} {
+		if !strings.Contains(stderr, filepath.Join(dir, name)+":3:") {
+			t.Errorf("error of %s not reported\nstderr:\n%s", name, stderr)
+		}
+	}
+	if !strings.Contains(stderr, "+1 ParseIP") {
+		t.Errorf("b.go not processed\nstderr:\n%s", stderr)
+	}
+}
+
+func TestTags(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a_linux.go":   dirtySrc,
+		"a_windows.go": dirtySrc,
+		"cgo.go":       "//go:build cgo\n\n" + dirtySrc,
+		"nocgo.go":     "//go:build !cgo\n\n" + dirtySrc,
+		"clean.go":     "//go:build windows\n\n" + cleanSrc,
+	})
+
+	stdout, stderr, _ := runMain(t, "", "-diff", "-tags", "linux,!cgo", dir)
+	for name, rewritten := range map[string]bool{
+		"a_linux.go":   true,
+		"nocgo.go":     true,
+		"a_windows.go": false,
+		"cgo.go":       false,
+	} {
+		path := filepath.Join(dir, name)
+		if got := strings.Contains(stdout, "diff "+path); got != rewritten {
+			t.Errorf("%s: rewritten %v, want %v", name, got, rewritten)
+		}
+		if got := strings.Contains(stderr, path+": +1 ParseIP, +netutils (excluded by -tags, not modified)"); got == rewritten {
+			t.Errorf("%s: reported as excluded %v, want %v\nstderr:\n%s", name, got, !rewritten, stderr)
+		}
+	}
+	if strings.Contains(stderr, "clean.go") {
+		t.Errorf("unchanged excluded file reported:\n%s", stderr)
+	}
+}
+
+func TestTagsNegated(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.go":        dirtySrc,
+		"a_linux.go":  dirtySrc,
+		"notlinux.go": "//go:build !linux\n\n" + dirtySrc,
+		"linux.go":    "//go:build linux\n\n" + dirtySrc,
+	})
+
+	stdout, stderr, _ := runMain(t, "", "-diff", "-tags", "!linux", dir)
+	for name, rewritten := range map[string]bool{
+		"a.go":        true,
+		"notlinux.go": true,
+		"a_linux.go":  false,
+		"linux.go":    false,
+	} {
+		path := filepath.Join(dir, name)
+		if got := strings.Contains(stdout, "diff "+path); got != rewritten {
+			t.Errorf("%s: rewritten %v, want %v\nstderr:\n%s", name, got, rewritten, stderr)
+		}
+	}
+}
+
+func TestGeneratedFiles(t *testing.T) {
+	generated := "// Code generated by gen. DO NOT EDIT.\n\n" + dirtySrc
+
+	dir := writeFiles(t, map[string]string{"gen.go": generated})
+	path := filepath.Join(dir, "gen.go")
+	_, stderr, code := runMain(t, "", "-v", path)
+	if code != 0 {
+		t.Fatalf("exit code %d\nstderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stderr, path+": skipped generated file") {
+		t.Errorf("skipped file not reported:\n%s", stderr)
+	}
+	if b, _ := os.ReadFile(path); string(b) != generated {
+		t.Errorf("generated file modified:\n%s", b)
+	}
+
+	if _, stderr, code := runMain(t, "", "-include-generated", path); code != 0 {
+		t.Fatalf("exit code %d\nstderr:\n%s", code, stderr)
+	}
+	if b, _ := os.ReadFile(path); !strings.Contains(string(b), "netutils.ParseIPSloppy") {
+		t.Errorf("generated file not rewritten with -include-generated:\n%s", b)
+	}
+}
+
+func TestList(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.go": dirtySrc, "clean.go": cleanSrc})
+	stdout, stderr, code := runMain(t, "", "-l", dir)
+	if code != 0 {
+		t.Fatalf("exit code %d\nstderr:\n%s", code, stderr)
+	}
+	if want := filepath.Join(dir, "a.go") + "\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.go")); string(b) != dirtySrc {
+		t.Errorf("-l modified the file")
+	}
+}
+
+func TestPatch(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	dir := writeFiles(t, map[string]string{
+		"a.go":     dirtySrc,
+		"sub/b.go": dirtySrc,
+		"clean.go": cleanSrc,
+	})
+	patchFile := filepath.Join(t.TempDir(), "fix.patch")
+
+	_, stderr, code := runMain(t, "", "-patch", patchFile, "-trim-path", dir, dir)
+	if code != 0 {
+		t.Fatalf("exit code %d\nstderr:\n%s", code, stderr)
+	}
+	data, err := os.ReadFile(patchFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"--- a/a.go\n+++ b/a.go\n", "--- a/sub/b.go\n+++ b/sub/b.go\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("patch does not contain %q:\n%s", want, data)
+		}
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.go")); string(b) != dirtySrc {
+		t.Errorf("-patch modified the file")
+	}
+
+	if _, err := runGit(dir, "apply", patchFile); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.go", "sub/b.go"} {
+		b, _ := os.ReadFile(filepath.Join(dir, name))
+		if !strings.Contains(string(b), "netutils.ParseIPSloppy") {
+			t.Errorf("%s not patched:\n%s", name, b)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"b.go":     "package main\n\nimport \"net\"\n\nvar ip = net.ParseIP(\"1.2.3.4\")\n",
+		"a.go":     dirtySrc,
+		"clean.go": cleanSrc,
+	})
+	stdout, stderr, code := runMain(t, "", "-json", "-trim-path", dir, dir)
+	if code != 0 {
+		t.Fatalf("exit code %d\nstderr:\n%s", code, stderr)
+	}
+	var results []Result
+	if err := json.Unmarshal([]byte(stdout), &results); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if len(results) != 2 || results[0].Filename != "a.go" || results[1].Filename != "b.go" {
+		t.Fatalf("unexpected results:\n%s", stdout)
+	}
+	if got := results[1]; got.Calls["ParseIP"] != 1 ||
+		!reflect.DeepEqual(got.ImportsAdded, []string{"k8s.io/utils/net"}) ||
+		!reflect.DeepEqual(got.ImportsRemoved, []string{"net"}) {
+		t.Errorf("unexpected result %+v", got)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.go")); string(b) != dirtySrc {
+		t.Errorf("-json modified the file")
+	}
+	for _, args := range [][]string{
+		{"-json", "-diff"},
+		{"-json", "-l"},
+		{"-json", "-patch", filepath.Join(t.TempDir(), "out.patch")},
+		{"-json", "-fix-and-verify-compile"},
+		{"-dry-summary", "-diff"},
+	} {
+		stdout, stderr, code := runMain(t, "", append(args, dir)...)
+		if code != 2 || stdout != "" || !strings.Contains(stderr, "-json and -dry-summary exclude -diff, -l, -patch and -fix-and-verify-compile") {
+			t.Errorf("%q: exit code %d, stdout:\n%s\nstderr:\n%s", args, code, stdout, stderr)
+		}
+	}
+}
+
+func TestVerifyCompile(t *testing.T) {
+	// The k8s.io/utils/net below lacks ParseCIDRSloppy.
+	undefined := `package bad
+
+import "net"
+
+func f(s string) (net.IP, *net.IPNet, error) {
+	return net.ParseCIDR(s)
+}
+`
+	for _, assumeYes := range []bool{false, true} {
+		t.Run(fmt.Sprintf("assume-yes=%v", assumeYes), func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{
+				"go.mod":           "module example.com/m\n\ngo 1.16\n\nrequire k8s.io/utils v0.0.0\n\nreplace k8s.io/utils => ./utils\n",
+				"utils/go.mod":     "module k8s.io/utils\n\ngo 1.16\n",
+				"utils/net/net.go": "package net\n\nimport \"net\"\n\nfunc ParseIPSloppy(s string) net.IP { return net.ParseIP(s) }\n",
+				"good/a.go":        strings.Replace(dirtySrc, "package main", "package good", 1),
+				"bad/a.go":         undefined,
+			})
+
+			args := []string{"-fix-and-verify-compile", filepath.Join(dir, "good"), filepath.Join(dir, "bad")}
+			if assumeYes {
+				args = append([]string{"-assume-yes"}, args...)
+			}
+			_, stderr, code := runMain(t, "", args...)
+			if code != 2 {
+				t.Errorf("exit code %d, want 2\nstderr:\n%s", code, stderr)
+			}
+			msg := ": not rewritten"
+			if assumeYes {
+				msg = ": rewritten, but the package does not compile"
+			}
+			if !strings.Contains(stderr, filepath.Join(dir, "bad")+msg) {
+				t.Errorf("type errors of bad not reported\nstderr:\n%s", stderr)
+			}
+			if b, _ := os.ReadFile(filepath.Join(dir, "bad", "a.go")); (string(b) != undefined) != assumeYes {
+				t.Errorf("bad/a.go rewritten: %v, want %v\n%s", string(b) != undefined, assumeYes, b)
+			}
+			if b, _ := os.ReadFile(filepath.Join(dir, "good", "a.go")); !strings.Contains(string(b), "netutils.ParseIPSloppy") {
+				t.Errorf("good/a.go not rewritten:\n%s\nstderr:\n%s", b, stderr)
+			}
+		})
+	}
+}
+
+func TestReadOnlyFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.go": dirtySrc, "b.go": dirtySrc})
+	path := filepath.Join(dir, "a.go")
+	if err := os.Chmod(path, 0444); err != nil {
+		t.Fatal(err)
+	}
+
+	_, stderr, code := runMain(t, "", dir)
+	if code != 2 {
+		t.Errorf("exit code %d, want 2", code)
+	}
+	if want := "cannot write " + path + ": permission denied"; !strings.Contains(stderr, want) {
+		t.Errorf("stderr does not contain %q:\n%s", want, stderr)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "b.go")); !strings.Contains(string(b), "netutils.ParseIPSloppy") {
+		t.Errorf("b.go not rewritten:\n%s", b)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("got %d files, want 2: temporary files left behind", len(entries))
+	}
+}
+
+func TestWriteFileFailure(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.go": dirtySrc})
+	path := filepath.Join(dir, "a.go")
+	renameFile = func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errors.New("no space left on device")}
+	}
+	defer func() { renameFile = os.Rename }()
+
+	err := writeFile(path, []byte(cleanSrc))
+	if err == nil || !strings.Contains(err.Error(), "cannot write "+path+": ") {
+		t.Errorf("got error %v, want a cannot write error", err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != dirtySrc {
+		t.Errorf("a.go modified:\n%s", b)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d files, want 1: temporary files left behind", len(entries))
+	}
+}
+
+func TestSummary(t *testing.T) {
+	ignored := strings.Replace(dirtySrc, "return net.ParseIP", "return net.ParseIP //sloppy:ignore\n\treturn net.ParseIP", 1)
+	dir := writeFiles(t, map[string]string{
+		"a/a.go": dirtySrc,
+		"a/b.go": cleanSrc,
+		"b/a.go": dirtySrc,
+		"b/b.go": ignored,
+		"b/c.go": "package main\n\nimport utilnet \"k8s.io/utils/net\"\n\nvar _ = utilnet.ParseIPSloppy\n",
+		"b/d.go": "package main\n\nimport netutils \"k8s.io/utils/net\"\n\nvar _ = netutils.ParseIPSloppy\n",
+	})
+
+	_, stderr, _ := runMain(t, "", "-l", "-summary", "-trim-path", dir+string(filepath.Separator), dir)
+	want := "a: fully migrated\n" +
+		"b: 1 file still using the strict parsers\n" +
+		"b: k8s.io/utils/net imported as netutils, utilnet\n"
+	if !strings.HasSuffix(stderr, want) {
+		t.Errorf("stderr does not end with the summary\n%s\nstderr:\n%s", want, stderr)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		args      []string
+		code      int
+		stdout    string
+		rewritten bool
+	}{
+		{args: []string{"fix"}, rewritten: true},
+		{args: []string{"check"}, code: 3, stdout: "a.go\n"},
+		{args: []string{"list"}, stdout: "a.go\n"},
+		{args: []string{"diff", "-diff-exit-code=false"}, stdout: "+\treturn netutils.ParseIPSloppy"},
+		{args: []string{"list", "-tags", "windows"}, stdout: ""},
+		{args: []string{"list", "-diff-exit-code=false"}, code: 2},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{
+				"a.go": "//go:build !windows\n\n" + dirtySrc,
+			})
+			args := append(tt.args, "-trim-path", dir+string(filepath.Separator), dir)
+			stdout, stderr, code := runMain(t, "", args...)
+			if code != tt.code {
+				t.Errorf("exit code %d, want %d\nstderr:\n%s", code, tt.code, stderr)
+			}
+			if tt.stdout == "" && stdout != "" || !strings.Contains(stdout, tt.stdout) {
+				t.Errorf("stdout:\n%s\nwant %q", stdout, tt.stdout)
+			}
+			b, err := os.ReadFile(filepath.Join(dir, "a.go"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rewritten := strings.Contains(string(b), "netutils"); rewritten != tt.rewritten {
+				t.Errorf("file rewritten: %v, want %v", rewritten, tt.rewritten)
+			}
+		})
+	}
+}
+
+func TestCommandNamedPath(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"list/a.go": dirtySrc})
+
+	stdout, stderr, code := runMainIn(t, dir, "", "list")
+	if code != 0 || stdout != "" {
+		t.Errorf("exit code %d, want 0 and no output\nstdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "list", "a.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "netutils") {
+		t.Errorf("the directory called list was not rewritten:\n%s", b)
+	}
+}
+
+func TestCommandWithoutPaths(t *testing.T) {
+	_, stderr, code := runMain(t, dirtySrc, "fix")
+	if code != 2 || !strings.Contains(stderr, "usage: sloppy-netparser fix") {
+		t.Errorf("exit code %d, want 2 and the usage\nstderr:\n%s", code, stderr)
+	}
+}
+
+func TestDrySummary(t *testing.T) {
+	both := strings.Replace(dirtySrc, "return net.ParseIP(\"1.2.3.4\")", "_, n, _ := net.ParseCIDR(\"1.2.3.0/24\")\n\treturn net.ParseIP(n.IP.String())", 1)
+	dir := writeFiles(t, map[string]string{
+		"a.go":     dirtySrc,
+		"b.go":     cleanSrc,
+		"sub/c.go": both,
+		"sub/d.go": "package main\n\nimport \"net\"\n\nvar ip = net.ParseIP(\"1.2.3.4\")\n",
+	})
+
+	stdout, stderr, code := runMain(t, "", "-dry-summary", dir)
+	if code != 0 {
+		t.Errorf("exit code %d, want 0\nstderr:\n%s", code, stderr)
+	}
+	want := "3 files would be rewritten\n" +
+		"3 files would gain the k8s.io/utils/net import\n" +
+		"1 file would lose the net import\n" +
+		"1 ParseCIDR and 3 ParseIP calls would be rewritten\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+	if stderr != "" {
+		t.Errorf("got per-file output:\n%s", stderr)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.go")); string(b) != dirtySrc {
+		t.Errorf("a.go rewritten:\n%s", b)
+	}
+}
+
+func TestCollectLiterals(t *testing.T) {
+	src := `package main
+
+import "net"
+
+var (
+	a = net.ParseIP("010.0.0.1")
+	b = net.ParseIP("1.2.3.4")
+	c = net.ParseIP(s)
+)
+
+func f() {
+	net.ParseCIDR("010.0.0.0/8")
+	net.ParseIP("010.0.0.1")
+}
+`
+	dir := writeFiles(t, map[string]string{"a.go": src})
+
+	stdout, stderr, code := runMain(t, "", "-collect-literals", "-trim-path", dir+string(filepath.Separator), dir)
+	if code != 0 {
+		t.Errorf("exit code %d, want 0\nstderr:\n%s", code, stderr)
+	}
+	want := `"010.0.0.0/8" a.go:12:16
+"010.0.0.1" a.go:6:18, a.go:13:14
+"1.2.3.4" a.go:7:18
+`
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.go")); string(b) != src {
+		t.Errorf("a.go rewritten:\n%s", b)
+	}
+}
+
+func TestFileModePreserved(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.go": dirtySrc, "b.go": dirtySrc})
+	modes := map[string]os.FileMode{"a.go": 0600, "b.go": 0755}
+	for name, mode := range modes {
+		if err := os.Chmod(filepath.Join(dir, name), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, stderr, code := runMain(t, "", dir); code != 0 {
+		t.Fatalf("exit code %d, want 0\nstderr:\n%s", code, stderr)
+	}
+	for name, mode := range modes {
+		path := filepath.Join(dir, name)
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != mode {
+			t.Errorf("%s: mode %v, want %v", name, fi.Mode().Perm(), mode)
+		}
+		if b, _ := os.ReadFile(path); !strings.Contains(string(b), "netutils.ParseIPSloppy") {
+			t.Errorf("%s not rewritten:\n%s", name, b)
+		}
+	}
+}
+
+func TestSymlinkPreserved(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"src/a.go": dirtySrc})
+	link := filepath.Join(dir, "link.go")
+	if err := os.Symlink(filepath.Join("src", "a.go"), link); err != nil {
+		t.Skip(err)
+	}
+
+	if _, stderr, code := runMain(t, "", link); code != 0 {
+		t.Fatalf("exit code %d, want 0\nstderr:\n%s", code, stderr)
+	}
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("link.go replaced by a regular file: %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "src", "a.go")); !strings.Contains(string(b), "netutils.ParseIPSloppy") {
+		t.Errorf("the target of link.go not rewritten:\n%s", b)
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d files, want 1: temporary files left behind", len(entries))
+	}
+}
+
+func TestFormatGofumpt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake gofumpt is a shell script")
+	}
+	// The fake gofumpt only removes the empty lines at the start of
+	// the blocks, like gofumpt does.
+	bin := writeFiles(t, map[string]string{"gofumpt": "#!/bin/sh\nsed '/{$/{n;/^$/d;}'\n"})
+	if err := os.Chmod(filepath.Join(bin, "gofumpt"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	src := `package main
+
+import "net"
+
+func f() net.IP {
+
+	return net.ParseIP("1.2.3.4")
+}
+`
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"gofmt", `package main
+
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+func f() net.IP {
+
+	return netutils.ParseIPSloppy("1.2.3.4")
+}
+`},
+		{"gofumpt", `package main
+
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+func f() net.IP {
+	return netutils.ParseIPSloppy("1.2.3.4")
+}
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{"a.go": src})
+			if _, stderr, code := runMain(t, "", "-fmt", tt.format, dir); code != 0 {
+				t.Fatalf("exit code %d, want 0\nstderr:\n%s", code, stderr)
+			}
+			if b, _ := os.ReadFile(filepath.Join(dir, "a.go")); string(b) != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", b, tt.want)
+			}
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		dir := writeFiles(t, map[string]string{"a.go": dirtySrc})
+		_, stderr, code := runMain(t, "", "-fmt", "goimports", dir)
+		if code != 2 || !strings.Contains(stderr, `unknown formatter "goimports"`) {
+			t.Errorf("exit code %d, want 2\nstderr:\n%s", code, stderr)
+		}
+	})
+}
+
+func TestReplacedNet(t *testing.T) {
+	// The rewrite matches the "net" import path, without resolving it:
+	// a replace directive pointing it at a fork does not matter.
+	dir := writeFiles(t, map[string]string{
+		"go.mod":           "module example.com/m\n\ngo 1.16\n\nreplace net => ./forks/net\n",
+		"forks/net/go.mod": "module net\n\ngo 1.16\n",
+		"forks/net/ip.go":  "package net\n\ntype IP []byte\n\nfunc ParseIP(s string) IP { return nil }\n",
+		"a.go":             dirtySrc,
+	})
+
+	if _, stderr, code := runMain(t, "", dir); code != 0 {
+		t.Fatalf("exit code %d, want 0\nstderr:\n%s", code, stderr)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.go")); !strings.Contains(string(b), "netutils.ParseIPSloppy") {
+		t.Errorf("a.go not rewritten:\n%s", b)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "forks", "net", "ip.go")); !strings.Contains(string(b), "func ParseIP") {
+		t.Errorf("the fork was rewritten:\n%s", b)
+	}
+}
+
+func TestReportOnlyRisky(t *testing.T) {
+	src := `package main
+
+import "net"
+
+var (
+	a = net.ParseIP("010.0.0.1")
+	b = net.ParseIP("1.2.3.4")
+	c = net.ParseIP(s)
+	d = net.ParseIP
+)
+
+func f() {
+	net.ParseCIDR("1.2.3.4/024")
+	net.ParseCIDR("010.0.0.0/8")
+	net.ParseCIDR("10.0.0.0/8")
+	net.ParseIP("::ffff:1.2.3.4")
+}
+`
+	dir := writeFiles(t, map[string]string{"a.go": src})
+
+	stdout, stderr, code := runMain(t, "", "-report-only-risky", "-trim-path", dir+string(filepath.Separator), dir)
+	if code != 0 {
+		t.Errorf("exit code %d, want 0\nstderr:\n%s", code, stderr)
+	}
+	want := `a.go:6:6: ParseIP("010.0.0.1") returns 10.0.0.1 once rewritten, instead of nil
+a.go:14:2: ParseCIDR("010.0.0.0/8") returns 10.0.0.0, 10.0.0.0/8 once rewritten, instead of an error
+a.go:8:6: unknown risk: ParseIP(...), the argument is not a literal
+a.go:9:6: unknown risk: ParseIP, a reference that is not a call
+`
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.go")); string(b) != src {
+		t.Errorf("a.go rewritten:\n%s", b)
+	}
+	for _, args := range [][]string{
+		{"-report-only-risky", "-l"},
+		{"-report-only-risky", "-diff"},
+		{"-collect-literals", "-l"},
+		{"-collect-literals", "-diff"},
+	} {
+		stdout, stderr, code := runMain(t, "", append(args, dir)...)
+		if code != 2 || stdout != "" || !strings.Contains(stderr, "-collect-literals and -report-only-risky exclude -diff, -l, -patch and -fix-and-verify-compile") {
+			t.Errorf("%q: exit code %d, stdout:\n%s\nstderr:\n%s", args, code, stdout, stderr)
+		}
+	}
+}
+
+func TestLSPEdits(t *testing.T) {
+	src := `package main
+
+import (
+	"fmt"
+	"net"
+)
+
+func main() {
+	fmt.Println(net.ParseIP("1.2.3.4"))
+}
+`
+	dir := writeFiles(t, map[string]string{"a.go": src, "clean.go": cleanSrc})
+	stdout, stderr, code := runMain(t, "", "-lsp-edits", dir)
+	if code != 0 {
+		t.Fatalf("exit code %d\nstderr:\n%s", code, stderr)
+	}
+	var got lspWorkspaceEdit
+	if err := json.Unmarshal([]byte(stdout), &got); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if len(got.DocumentChanges) != 1 {
+		t.Fatalf("got %d document changes, want 1:\n%s", len(got.DocumentChanges), stdout)
+	}
+	c := got.DocumentChanges[0]
+	if uri := "file://" + filepath.ToSlash(filepath.Join(dir, "a.go")); c.TextDocument.URI != uri || c.TextDocument.Version != nil {
+		t.Errorf("textDocument %q, version %v, want %q and null", c.TextDocument.URI, c.TextDocument.Version, uri)
+	}
+	edit := func(line, start, end int, text string) lspTextEdit {
+		return lspTextEdit{lspRange{lspPosition{line, start}, lspPosition{line, end}}, text}
+	}
+	want := []lspTextEdit{
+		edit(4, 0, 6, "\n\tnetutils \"k8s.io/utils/net\""),
+		edit(8, 13, 16, "netutils"),
+		edit(8, 17, 24, "ParseIPSloppy"),
+	}
+	if !reflect.DeepEqual(c.Edits, want) {
+		t.Errorf("got edits %+v, want %+v", c.Edits, want)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.go")); string(b) != src {
+		t.Errorf("-lsp-edits modified the file")
+	}
+}
+
+func TestManifest(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.go": dirtySrc, "b.go": dirtySrc, "clean.go": cleanSrc})
+	manifestPath := filepath.Join(t.TempDir(), "manifest.json")
+	if _, stderr, code := runMain(t, "", "-manifest", manifestPath, dir); code != 0 {
+		t.Fatalf("exit code %d\nstderr:\n%s", code, stderr)
+	}
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []manifestEntry
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, data)
+	}
+
+	hash := func(b []byte) string {
+		sum := sha256.Sum256(b)
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	var want []manifestEntry
+	for _, name := range []string{"a.go", "b.go"} {
+		path := filepath.Join(dir, name)
+		after, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, manifestEntry{Path: path, Before: hash([]byte(dirtySrc)), After: hash(after)})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got manifest %+v, want %+v", got, want)
+	}
+	if matches, _ := filepath.Glob(manifestPath + ".*"); len(matches) != 0 {
+		t.Errorf("temporary files left: %q", matches)
+	}
+}
+
+func TestExcludeDir(t *testing.T) {
+	files := map[string]string{
+		"a.go":                 dirtySrc,
+		"third_party/b.go":     dirtySrc,
+		"pkg/third_party/c.go": dirtySrc,
+		"vendor/d.go":          dirtySrc,
+		"pkg/testdata/e.go":    dirtySrc,
+		"third_party_x/f.go":   dirtySrc,
+	}
+	tests := []struct {
+		args      []string
+		rewritten []string
+	}{
+		{nil, []string{"a.go", "third_party/b.go", "pkg/third_party/c.go", "third_party_x/f.go"}},
+		{[]string{"-exclude-dir", "third_party"}, []string{"a.go", "third_party_x/f.go"}},
+		{[]string{"-exclude-dir=", "-exclude-dir", "third_party"}, []string{"a.go", "vendor/d.go", "pkg/testdata/e.go", "third_party_x/f.go"}},
+	}
+	for _, tt := range tests {
+		dir := writeFiles(t, files)
+		if _, stderr, code := runMain(t, "", append(tt.args, dir)...); code != 0 {
+			t.Fatalf("%q: exit code %d\nstderr:\n%s", tt.args, code, stderr)
+		}
+		rewritten := make(map[string]bool)
+		for _, name := range tt.rewritten {
+			rewritten[name] = true
+		}
+		for name := range files {
+			b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(b) != dirtySrc; got != rewritten[name] {
+				t.Errorf("%q: %s rewritten: %v, want %v", tt.args, name, got, rewritten[name])
+			}
+		}
+	}
+
+	// The directories given are walked, whatever their name.
+	dir := writeFiles(t, files)
+	if _, stderr, code := runMain(t, "", filepath.Join(dir, "vendor")); code != 0 {
+		t.Fatalf("exit code %d\nstderr:\n%s", code, stderr)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "vendor", "d.go")); string(b) == dirtySrc {
+		t.Errorf("vendor/d.go not rewritten")
+	}
+}
+
+func TestNoImportResolution(t *testing.T) {
+	// goimports would find the foo package in GOPATH, and add its
+	// import.
+	gopath := writeFiles(t, map[string]string{"src/foo/foo.go": "package foo\n\nfunc Bar() {}\n"})
+	env := map[string]string{
+		"GOPATH":      gopath,
+		"GO111MODULE": "off",
+		"GOPROXY":     "off",
+		"GOFLAGS":     "",
+	}
+	for k, v := range env {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+
+	src := `package main
+
+import "net"
+
+func f() {
+	foo.Bar()
+	net.ParseIP("1.2.3.4")
+}
+`
+	dir := writeFiles(t, map[string]string{"a.go": src})
+	if _, stderr, code := runMain(t, "", dir); code != 0 {
+		t.Fatalf("exit code %d\nstderr:\n%s", code, stderr)
+	}
+	want := `package main
+
+import (
+	netutils "k8s.io/utils/net"
+)
+
+func f() {
+	foo.Bar()
+	netutils.ParseIPSloppy("1.2.3.4")
+}
+`
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.go")); string(b) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b, want)
+	}
+}
+
+func TestSummaryOnly(t *testing.T) {
+	files := map[string]string{
+		"a.go":     dirtySrc,
+		"b.go":     "package main\n\nimport \"net\"\n\nvar _, _, _ = net.ParseCIDR(\"10.0.0.0/8\")\nvar _ = net.ParseIP(\"::1\")\n",
+		"clean.go": cleanSrc,
+	}
+	tests := []struct {
+		args   []string
+		code   int
+		stderr string
+		write  bool
+	}{
+		{[]string{"-summary-only"}, 0, "2 files rewritten, 1 ParseCIDR and 2 ParseIP calls\n", true},
+		{[]string{"check", "-summary-only"}, 3, "2 files would be rewritten, 1 ParseCIDR and 2 ParseIP calls\n", false},
+	}
+	for _, tt := range tests {
+		dir := writeFiles(t, files)
+		stdout, stderr, code := runMain(t, "", append(tt.args, dir)...)
+		if code != tt.code {
+			t.Errorf("%q: exit code %d, want %d", tt.args, code, tt.code)
+		}
+		if stdout != "" || stderr != tt.stderr {
+			t.Errorf("%q: got stdout %q and stderr %q, want only %q on stderr", tt.args, stdout, stderr, tt.stderr)
+		}
+		b, _ := os.ReadFile(filepath.Join(dir, "a.go"))
+		if written := string(b) != dirtySrc; written != tt.write {
+			t.Errorf("%q: a.go written: %v, want %v", tt.args, written, tt.write)
+		}
+	}
+}
+
+func TestSkipTemplates(t *testing.T) {
+	tmpl := `package {{ .Package }}
+
+import "net"
+
+func parse() net.IP {
+	return net.ParseIP({{ printf "%q" .Addr }})
+}
+`
+	files := map[string]string{
+		"a.go":         dirtySrc,
+		"b.go.tmpl":    tmpl,
+		"c.gotmpl":     tmpl,
+		"d.tmpl":       tmpl,
+		"sub/e.go":     dirtySrc,
+		"sub/f.gotmpl": tmpl,
+	}
+	dir := writeFiles(t, files)
+	var args []string
+	for _, name := range []string{"a.go", "b.go.tmpl", "c.gotmpl", "d.tmpl", "sub"} {
+		args = append(args, filepath.Join(dir, name))
+	}
+	_, stderr, code := runMain(t, "", args...)
+	if code != 0 {
+		t.Fatalf("exit code %d\nstderr:\n%s", code, stderr)
+	}
+	for _, name := range []string{"b.go.tmpl", "c.gotmpl", "d.tmpl"} {
+		want := fmt.Sprintf("warning: %s: skipped, not a .go file\n", filepath.Join(dir, name))
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr does not contain %q:\n%s", want, stderr)
+		}
+	}
+	if strings.Contains(stderr, "f.gotmpl") || strings.Contains(stderr, "expected") {
+		t.Errorf("unexpected stderr:\n%s", stderr)
+	}
+	for name, src := range files {
+		b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rewritten := string(b) != src; rewritten != strings.HasSuffix(name, ".go") {
+			t.Errorf("%s rewritten: %v", name, rewritten)
+		}
+	}
+}
+
+func TestDumpRules(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.go": dirtySrc})
+	stdout, stderr, code := runMain(t, "", "-dump-rules", "-also-from", "example.com/netx", dir)
+	if code != 0 {
+		t.Fatalf("exit code %d\nstderr:\n%s", code, stderr)
+	}
+	want := `PACKAGE           NAME       TARGET            NAME             ALIAS
+example.com/netx  ParseCIDR  k8s.io/utils/net  ParseCIDRSloppy  netutils
+example.com/netx  ParseIP    k8s.io/utils/net  ParseIPSloppy    netutils
+net               ParseCIDR  k8s.io/utils/net  ParseCIDRSloppy  netutils
+net               ParseIP    k8s.io/utils/net  ParseIPSloppy    netutils
+`
+	if stdout != want {
+		t.Errorf("--- have\n%s\n--- want\n%s", stdout, want)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "a.go")); err != nil || string(b) != dirtySrc {
+		t.Errorf("a.go modified: %q, %v", b, err)
+	}
+}
+
+func TestOnError(t *testing.T) {
+	files := map[string]string{
+		"a.go": dirtySrc,
+		"b.go": "package main\n\nfunc f() { net.ParseIP( }\n",
+		"c.go": dirtySrc,
+	}
+	tests := []struct {
+		policy    string
+		rewritten []string
+	}{
+		{"continue", []string{"a.go", "c.go"}},
+		{"stop", []string{"a.go"}},
+	}
+	for _, tt := range tests {
+		dir := writeFiles(t, files)
+		_, stderr, code := runMain(t, "", "-on-error", tt.policy, dir)
+		if code != 2 {
+			t.Errorf("%s: exit code %d, want 2\nstderr:\n%s", tt.policy, code, stderr)
+		}
+		if !strings.Contains(stderr, filepath.Join(dir, "b.go")) {
+			t.Errorf("%s: the error of b.go is not reported:\n%s", tt.policy, stderr)
+		}
+		rewritten := make(map[string]bool)
+		for _, name := range tt.rewritten {
+			rewritten[name] = true
+		}
+		for _, name := range []string{"a.go", "c.go"} {
+			b, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(b) != dirtySrc; got != rewritten[name] {
+				t.Errorf("%s: %s rewritten: %v, want %v", tt.policy, name, got, rewritten[name])
+			}
+		}
+	}
+
+	if _, stderr, code := runMain(t, "", "-on-error", "retry", "."); code != 2 || !strings.Contains(stderr, "unknown policy") {
+		t.Errorf("-on-error retry: exit code %d, stderr:\n%s", code, stderr)
+	}
+	if _, stderr, code := runMain(t, "", "-on-error", "stop", "-max-errors", "5", "."); code != 2 || !strings.Contains(stderr, "-on-error stop excludes -max-errors") {
+		t.Errorf("-on-error stop -max-errors 5: exit code %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestIgnoreFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.go":     dirtySrc,
+		"b.go":     dirtySrc,
+		"sub/c.go": dirtySrc,
+	})
+	// a.go is ignored even though given, and sub/c.go, ignored by a
+	// path relative to the working directory, when walked.
+	a := filepath.Join(dir, "a.go")
+	_, stderr, code := runMainIn(t, dir, "", "-ignore-file", a, "-ignore-file", filepath.Join("sub", "c.go"), a, dir)
+	if code != 0 {
+		t.Fatalf("exit code %d\nstderr:\n%s", code, stderr)
+	}
+	for name, rewritten := range map[string]bool{"a.go": false, "b.go": true, "sub/c.go": false} {
+		b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b) != dirtySrc; got != rewritten {
+			t.Errorf("%s rewritten: %v, want %v", name, got, rewritten)
+		}
+	}
+}
+
+func TestSummaryThreshold(t *testing.T) {
+	files := map[string]string{
+		"a.go":     dirtySrc,
+		"b.go":     cleanSrc,
+		"sub/c.go": dirtySrc,
+		"sub/d.go": "package main\n\nimport \"net\"\n\nvar ip = net.ParseIP(\"1.2.3.4\") //sloppy:ignore\n",
+	}
+	tests := []struct {
+		threshold string
+		want      int
+	}{
+		{"0", 3},
+		{"1", 3},
+		{"2", 0},
+		{"10", 0},
+	}
+	for _, tt := range tests {
+		dir := writeFiles(t, files)
+		_, stderr, code := runMain(t, "", "-summary-threshold", tt.threshold, dir)
+		if code != tt.want {
+			t.Errorf("-summary-threshold %s: exit code %d, want %d\nstderr:\n%s", tt.threshold, code, tt.want, stderr)
+		}
+		want := "2 calls to the strict parsers left, at most " + tt.threshold + " allowed\n"
+		if stderr != want {
+			t.Errorf("-summary-threshold %s: stderr %q, want %q", tt.threshold, stderr, want)
+		}
+		for name, src := range files {
+			if b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name))); err != nil || string(b) != src {
+				t.Errorf("%s modified: %q, %v", name, b, err)
+			}
+		}
+	}
+
+	dir := writeFiles(t, files)
+	for _, flag := range []string{"-json", "-jsonl"} {
+		stdout, stderr, code := runMain(t, "", "-summary-threshold", "5", flag, dir)
+		if code != 2 || stdout != "" || !strings.Contains(stderr, "-summary-threshold excludes -json and -jsonl") {
+			t.Errorf("%s: exit code %d, stdout:\n%s\nstderr:\n%s", flag, code, stdout, stderr)
+		}
+	}
+}
+
+func TestProm(t *testing.T) {
+	files := map[string]string{
+		"a.go":     dirtySrc,
+		"sub/b.go": dirtySrc,
+		"sub/c.go": "package main\n\nimport \"net\"\n\nvar _, n, _ = net.ParseCIDR(\"10.0.0.0/8\")\n",
+	}
+	dir := writeFiles(t, files)
+	prom := filepath.Join(t.TempDir(), "migration.prom")
+	_, stderr, code := runMain(t, "", "-prom", prom, "-label", "repo=x", "-label", `team=net "core"`, dir)
+	if code != 0 {
+		t.Fatalf("exit code %d\nstderr:\n%s", code, stderr)
+	}
+	b, err := os.ReadFile(prom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP sloppy_net_parsecidr_remaining The ParseCIDR calls left to rewrite.
+# TYPE sloppy_net_parsecidr_remaining gauge
+sloppy_net_parsecidr_remaining{repo="x",team="net \"core\""} 1
+# HELP sloppy_net_parseip_remaining The ParseIP calls left to rewrite.
+# TYPE sloppy_net_parseip_remaining gauge
+sloppy_net_parseip_remaining{repo="x",team="net \"core\""} 2
+`
+	if string(b) != want {
+		t.Errorf("--- have\n%s\n--- want\n%s", b, want)
+	}
+
+	// Check the exposition format: the comments, then the samples, of
+	// a metric declared once, with quoted label values.
+	sample := regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\["\\n])*"(?:,[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\["\\n])*")*\})? (\S+)$`)
+	comment := regexp.MustCompile(`^# (HELP|TYPE) ([a-zA-Z_:][a-zA-Z0-9_:]*) (.*)$`)
+	types := make(map[string]string)
+	for i, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+		if m := comment.FindStringSubmatch(line); m != nil {
+			if m[1] == "TYPE" {
+				if _, ok := types[m[2]]; ok {
+					t.Errorf("line %d: %s declared twice", i+1, m[2])
+				}
+				types[m[2]] = m[3]
+			}
+			continue
+		}
+		m := sample.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("line %d: invalid sample %q", i+1, line)
+			continue
+		}
+		if types[m[1]] != "gauge" {
+			t.Errorf("line %d: %s is not declared a gauge", i+1, m[1])
+		}
+		if _, err := strconv.ParseFloat(m[3], 64); err != nil {
+			t.Errorf("line %d: invalid value %q", i+1, m[3])
+		}
+	}
+	for name, src := range files {
+		if b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name))); err != nil || string(b) != src {
+			t.Errorf("%s modified: %q, %v", name, b, err)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"-prom", prom, "-diff"},
+		{"-prom", prom, "-l"},
+		{"-summary-threshold", "5", "-diff"},
+		{"-summary-threshold", "5", "-fix-and-verify-compile"},
+	} {
+		stdout, stderr, code := runMain(t, "", append(args, dir)...)
+		if code != 2 || stdout != "" || !strings.Contains(stderr, "-summary-threshold and -prom exclude -diff, -l, -patch and -fix-and-verify-compile") {
+			t.Errorf("%q: exit code %d, stdout:\n%s\nstderr:\n%s", args, code, stdout, stderr)
+		}
+	}
+}
+
+func TestApplyOnce(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.go": dirtySrc, lockName: "12345\n"})
+	_, stderr, code := runMain(t, "", "-apply-once", dir)
+	if code != 2 || !strings.Contains(stderr, "locked by the run of process 12345") {
+		t.Errorf("exit code %d, want 2 and a lock error\nstderr:\n%s", code, stderr)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "a.go")); err != nil || string(b) != dirtySrc {
+		t.Errorf("a.go rewritten while locked: %q, %v", b, err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, lockName)); err != nil {
+		t.Fatal(err)
+	}
+	if _, stderr, code := runMain(t, "", "-apply-once", dir); code != 0 {
+		t.Fatalf("exit code %d\nstderr:\n%s", code, stderr)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "a.go")); err != nil || string(b) == dirtySrc {
+		t.Errorf("a.go not rewritten: %q, %v", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, lockName)); !os.IsNotExist(err) {
+		t.Errorf("the lock is not released: %v", err)
+	}
+}
+
+func TestPrinterConfig(t *testing.T) {
+	src := `package main
+
+import "net"
+
+type T struct {
+	IP   net.IP // the address
+	Name string // its name
+}
+
+func f() T {
+	if true {
+		return T{IP: net.ParseIP("1.2.3.4")}
+	}
+	return T{}
+}
+`
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-tabwidth", "4", "-use-spaces"}, `package main
+
+import (
+    "net"
+
+    netutils "k8s.io/utils/net"
+)
+
+type T struct {
+    IP   net.IP // the address
+    Name string // its name
+}
+
+func f() T {
+    if true {
+        return T{IP: netutils.ParseIPSloppy("1.2.3.4")}
+    }
+    return T{}
+}
+`},
+		// The columns are at least a tab wide, like with gofmt -tabs=false.
+		{[]string{"-use-spaces"}, `package main
+
+import (
+        "net"
+
+        netutils "k8s.io/utils/net"
+)
+
+type T struct {
+        IP      net.IP  // the address
+        Name    string  // its name
+}
+
+func f() T {
+        if true {
+                return T{IP: netutils.ParseIPSloppy("1.2.3.4")}
+        }
+        return T{}
+}
+`},
+	}
+	for _, tt := range tests {
+		dir := writeFiles(t, map[string]string{"a.go": src})
+		if _, stderr, code := runMain(t, "", append(tt.args, dir)...); code != 0 {
+			t.Fatalf("%q: exit code %d\nstderr:\n%s", tt.args, code, stderr)
+		}
+		b, err := os.ReadFile(filepath.Join(dir, "a.go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("%q:\n--- have\n%s\n--- want\n%s", tt.args, b, tt.want)
+		}
+	}
+
+	if _, stderr, code := runMain(t, "", "-use-spaces", "-no-format", "."); code != 2 || !strings.Contains(stderr, "exclude") {
+		t.Errorf("-use-spaces -no-format: exit code %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestJSONLines(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.go":     dirtySrc,
+		"b.go":     "package main\n\nimport \"net\"\n\nvar ip = net.ParseIP(\"1.2.3.4\")\n",
+		"clean.go": cleanSrc,
+		"sub/c.go": dirtySrc,
+	})
+	tests := []struct {
+		args  []string
+		files []string
+	}{
+		// The lines come in the order the files are processed.
+		{[]string{"-jsonl"}, []string{filepath.Join("sub", "c.go"), "a.go", "b.go"}},
+		{[]string{"-jsonl", "-sort"}, []string{"a.go", "b.go", filepath.Join("sub", "c.go")}},
+	}
+	for _, tt := range tests {
+		args := append(tt.args, "-trim-path", dir, filepath.Join(dir, "sub"), dir)
+		stdout, stderr, code := runMain(t, "", args...)
+		if code != 0 {
+			t.Fatalf("%q: exit code %d\nstderr:\n%s", tt.args, code, stderr)
+		}
+		var files []string
+		for _, line := range strings.SplitAfter(stdout, "\n") {
+			if line == "" {
+				continue
+			}
+			var obj map[string]interface{}
+			if !strings.HasSuffix(line, "\n") || json.Unmarshal([]byte(line), &obj) != nil {
+				t.Fatalf("%q: line %q is not a JSON object", tt.args, line)
+			}
+			var res Result
+			if err := json.Unmarshal([]byte(line), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Calls["ParseIP"] != 1 {
+				t.Errorf("%q: unexpected result %+v", tt.args, res)
+			}
+			files = append(files, res.Filename)
+		}
+		if !reflect.DeepEqual(files, tt.files) {
+			t.Errorf("%q: got the files %q, want %q", tt.args, files, tt.files)
+		}
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.go")); string(b) != dirtySrc {
+		t.Errorf("-jsonl modified the file")
+	}
+	if _, stderr, code := runMain(t, "", "-sort", dir); code != 2 || !strings.Contains(stderr, "-sort requires -jsonl") {
+		t.Errorf("-sort alone: exit code %d, stderr:\n%s", code, stderr)
+	}
+	if stdout, stderr, code := runMain(t, "", "-json", "-jsonl", dir); code != 2 || stdout != "" || !strings.Contains(stderr, "-jsonl excludes -json") {
+		t.Errorf("-json -jsonl: exit code %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+}
+
+func TestRenameExistingAlias(t *testing.T) {
+	utilnetSrc := "package main\n\nimport (\n\t\"net\"\n\n\tutilnet \"k8s.io/utils/net\"\n)\n\nvar a = utilnet.IsIPv6(net.ParseIP(\"::1\"))\n"
+	for _, rename := range []bool{true, false} {
+		dir := writeFiles(t, map[string]string{
+			"a.go": utilnetSrc,
+			"b.go": utilnetSrc,
+			"c.go": dirtySrc,
+		})
+		_, stderr, code := runMain(t, "", fmt.Sprintf("-rename-existing-alias=%v", rename), dir)
+		if code != 0 {
+			t.Fatalf("exit code %d\nstderr:\n%s", code, stderr)
+		}
+		want, other := "netutils", "utilnet"
+		if !rename {
+			want, other = other, want
+		}
+		for _, name := range []string{"a.go", "b.go", "c.go"} {
+			b, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(b), want+` "k8s.io/utils/net"`) || strings.Contains(string(b), other) {
+				t.Errorf("-rename-existing-alias=%v: %s does not import %s only:\n%s", rename, name, want, b)
+			}
+		}
+	}
+}
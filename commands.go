@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// command is a subcommand of the CLI, like "sloppy-netparser diff ./...".
+// Its flags are the command line flags of the same name, so
+// "sloppy-netparser diff -tags linux" is "sloppy-netparser -diff -tags linux".
+type command struct {
+	name, help string
+	// flags are the names of the command line flags the command accepts,
+	// in addition to commonFlags.
+	flags []string
+	// setMode sets the flags selecting the mode of the command.
+	setMode func()
+}
+
+// commonFlags are the flags accepted by every command.
+var commonFlags = []string{
+	"git", "v", "gopath-mode", "trim-path", "tags", "include-generated",
+	"keep-net-import", "max-errors", "summary", "wrappers", "timeout", "fmt",
+	"no-format", "follow-symlinks", "exclude-dir", "also-from",
+	"summary-only", "rename-existing-alias", "read-ahead", "dump-rules",
+	"on-error", "ignore-file", "summary-threshold", "prom", "label",
+	"apply-once", "tabwidth", "use-spaces",
+}
+
+// checkOnly is set by the check command: the files that would be
+// rewritten are listed, and make the run exit with status 3.
+var checkOnly = false
+
+var commands = []command{
+	{
+		name:    "fix",
+		help:    "rewrite the files",
+		flags:   []string{"fix-and-verify-compile", "assume-yes", "progress", "manifest"},
+		setMode: func() {},
+	},
+	{
+		name:    "check",
+		help:    "list the files that would be rewritten, and exit with status 3 if any",
+		setMode: func() { *list, checkOnly = true, true },
+	},
+	{
+		name:    "list",
+		help:    "list the files that would be rewritten",
+		setMode: func() { *list = true },
+	},
+	{
+		name:    "diff",
+		help:    "display the diffs of the files that would be rewritten",
+		flags:   []string{"diff-exit-code"},
+		setMode: func() { *doDiff = true },
+	},
+}
+
+// lookupCommand returns the command called name, or nil. An existing
+// path is not a command, so that a directory called fix, for example,
+// is processed like the other paths: its files are rewritten, and the
+// commands are available as flags, like -l for list.
+func lookupCommand(name string) *command {
+	if _, err := os.Lstat(name); err == nil {
+		return nil
+	}
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// parse parses the flags of the command in args, sets its mode,
+// and returns the paths to process. It exits with status 2 on errors,
+// like flag.Parse.
+func (c *command) parse(args []string) []string {
+	fs := flag.NewFlagSet("sloppy-netparser "+c.name, flag.ExitOnError)
+	for _, name := range append(append([]string(nil), commonFlags...), c.flags...) {
+		f := flag.Lookup(name)
+		fs.Var(f.Value, f.Name, f.Usage)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: sloppy-netparser %s [flags] path ...\n\n%s.\n\n", c.name, strings.ToUpper(c.help[:1])+c.help[1:])
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 && *gitBase == "" {
+		fs.Usage()
+	}
+	c.setMode()
+	return fs.Args()
+}
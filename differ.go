@@ -0,0 +1,24 @@
+package main
+
+// Differ computes the diff between two versions a and b of the file
+// name, in the unified format of diff -u: the --- and +++ header lines
+// first, then the hunks.
+type Differ interface {
+	Diff(name string, a, b []byte) ([]byte, error)
+}
+
+// DifferFunc adapts a function to the Differ interface.
+type DifferFunc func(name string, a, b []byte) ([]byte, error)
+
+// Diff calls f(name, a, b).
+func (f DifferFunc) Diff(name string, a, b []byte) ([]byte, error) {
+	return f(name, a, b)
+}
+
+// toolDiffer is the default Differ, running the diff tool.
+var toolDiffer Differ = DifferFunc(func(name string, a, b []byte) ([]byte, error) {
+	return Diff("go-fix", a, b)
+})
+
+// differ is the Differ used by -diff and -patch.
+var differ = toolDiffer
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestDiffer(t *testing.T) {
+	defer func() { differ = toolDiffer }()
+	var calls []string
+	differ = DifferFunc(func(name string, a, b []byte) ([]byte, error) {
+		calls = append(calls, name+": "+string(a)+" -> "+string(b))
+		return []byte("--- a\n+++ b\n@@ -1 +1 @@\n-old\n+new\n"), nil
+	})
+
+	data, err := patchDiff("dir/a.go", []byte("old"), []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "diff --git a/dir/a.go b/dir/a.go\n--- a/dir/a.go\n+++ b/dir/a.go\n@@ -1 +1 @@\n-old\n+new\n"
+	if string(data) != want {
+		t.Errorf("got patch:\n%s\nwant:\n%s", data, want)
+	}
+	if len(calls) != 1 || calls[0] != "dir/a.go: old -> new" {
+		t.Errorf("differ calls %q, want one for dir/a.go", calls)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"io"
+	"os"
+)
+
+// dumpAST prints the AST of the Go file filename, and its imports
+// with their local names, to w. It is a debugging aid to find out
+// why a call was not rewritten.
+func dumpAST(w io.Writer, filename string) error {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	f, err := parser.ParseFile(fset, filename, src, parserMode)
+	if err != nil {
+		return err
+	}
+	if err := ast.Fprint(w, fset, f, ast.NotNilFilter); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "imports:")
+	for _, s := range f.Imports {
+		fmt.Fprintf(w, "\t%s %q\n", importName(s), importPath(s))
+	}
+	return nil
+}
@@ -10,6 +10,7 @@ import (
 	"go/token"
 	"path"
 	"strconv"
+	"strings"
 )
 
 // walk traverses the AST x, calling visit(y) for each node y in the tree but
@@ -277,6 +278,53 @@ func importSpec(f *ast.File, path string) *ast.ImportSpec {
 	return nil
 }
 
+// importName returns the local name of the package imported by s:
+// its explicit name, or the last element of its path.
+func importName(s *ast.ImportSpec) string {
+	if s.Name != nil {
+		return s.Name.Name
+	}
+	_, name := path.Split(importPath(s))
+	return name
+}
+
+// freeName returns the name to import path as in f: base, unless
+// another import or a declaration of f, at any scope, already binds
+// it, in which case the first free name of base2, base3, ... is used.
+// The sequence only depends on f, so a file always gets the same name.
+func freeName(f *ast.File, base, path string) string {
+	bound := make(map[string]bool)
+	for _, s := range f.Imports {
+		if importPath(s) != path {
+			bound[importName(s)] = true
+		}
+	}
+	// Every identifier declaring or referring to an object binds its
+	// name, at any scope: a local variable would shadow the import.
+	ast.Inspect(f, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Obj != nil {
+			bound[id.Name] = true
+		}
+		return true
+	})
+	name := base
+	for i := 2; bound[name]; i++ {
+		name = base + strconv.Itoa(i)
+	}
+	return name
+}
+
+// importSpecs returns all the import specs of path in f.
+func importSpecs(f *ast.File, path string) []*ast.ImportSpec {
+	var specs []*ast.ImportSpec
+	for _, s := range f.Imports {
+		if importPath(s) == path {
+			specs = append(specs, s)
+		}
+	}
+	return specs
+}
+
 // importPath returns the unquoted import path of s,
 // or "" if the path is not properly quoted.
 func importPath(s *ast.ImportSpec) string {
@@ -387,6 +435,12 @@ func matchLen(x, y string) int {
 	return i
 }
 
+// isThirdParty reports whether path is not in the standard library:
+// the paths of the standard library have no dot, unlike example.com.
+func isThirdParty(path string) bool {
+	return strings.Contains(path, ".")
+}
+
 // addImport adds the import path to the file f, if absent.
 func addImport(f *ast.File, iname, ipath string) (added bool) {
 	// check if import exist with the same alias
@@ -418,6 +472,8 @@ func addImport(f *ast.File, iname, ipath string) (added bool) {
 		lastImport = -1
 		impDecl    *ast.GenDecl
 		impIndex   = -1
+
+		seenThirdParty = false
 	)
 	for i, decl := range f.Decls {
 		gen, ok := decl.(*ast.GenDecl)
@@ -430,14 +486,19 @@ func addImport(f *ast.File, iname, ipath string) (added bool) {
 			}
 
 			// Compute longest shared prefix with imports in this block.
+			// Without a shared prefix, a third-party path goes after
+			// the first third-party import, not in the standard library
+			// group.
 			for j, spec := range gen.Specs {
 				impspec := spec.(*ast.ImportSpec)
-				n := matchLen(importPath(impspec), ipath)
-				if n > bestMatch {
+				p := importPath(impspec)
+				n := matchLen(p, ipath)
+				if n > bestMatch || (bestMatch == 0 && !seenThirdParty && isThirdParty(ipath)) {
 					bestMatch = n
 					impDecl = gen
 					impIndex = j
 				}
+				seenThirdParty = seenThirdParty || isThirdParty(p)
 			}
 		}
 	}
@@ -0,0 +1,38 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+// FuzzRewrite checks that Rewrite does not panic, and that it turns
+// valid Go into valid Go. The import fixers are not fuzzed: the
+// astutil one does not depend on the build environment.
+func FuzzRewrite(f *testing.F) {
+	for _, tt := range testCases {
+		f.Add(tt.In)
+	}
+	f.Add(dirtySrc)
+	f.Add(cleanSrc)
+
+	fixImports = astutilImports
+	defer func() { fixImports = formatImports }()
+	f.Fuzz(func(t *testing.T, in string) {
+		if _, err := parser.ParseFile(token.NewFileSet(), "a.go", in, parserMode); err != nil {
+			// Only check for panics.
+			Rewrite([]byte(in), "a.go")
+			return
+		}
+		out, _, err := Rewrite([]byte(in), "a.go")
+		if err != nil {
+			return
+		}
+		if _, err := parser.ParseFile(token.NewFileSet(), "a.go", out, parserMode); err != nil {
+			t.Errorf("invalid output: %v\n--- input\n%s\n--- output\n%s", err, in, out)
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// gitChangedFiles returns the Go files added, copied, modified or
+// renamed between base and HEAD in the git repository containing dir.
+// git reports paths relative to the repository root, so they are
+// joined to it and made relative to dir when possible.
+func gitChangedFiles(dir, base string) ([]string, error) {
+	if strings.HasPrefix(base, "-") {
+		return nil, fmt.Errorf("-git: invalid base %q", base)
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("-git requires git: %v", err)
+	}
+	top, err := runGit(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	top = strings.TrimSpace(top)
+
+	// -z keeps the names git would quote, with spaces or non-ASCII.
+	out, err := runGit(dir, "diff", "--name-only", "-z", "--diff-filter=ACMR", base+"...HEAD", "--")
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, name := range strings.Split(out, "\x00") {
+		if !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		path := filepath.Join(top, filepath.FromSlash(name))
+		if rel, err := filepath.Rel(dir, path); err == nil {
+			path = rel
+		}
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+// withinPaths returns the files that are one of paths or inside one
+// of them, so that -git can be restricted to some directories.
+func withinPaths(files, paths []string) ([]string, error) {
+	roots := make([]string, len(paths))
+	for i, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		roots[i] = abs
+	}
+	var kept []string
+	for _, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, root := range roots {
+			if rel, err := filepath.Rel(root, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				kept = append(kept, file)
+				break
+			}
+		}
+	}
+	return kept, nil
+}
+
+// runGit runs git with args in dir and returns its standard output.
+func runGit(dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s: %s", strings.Join(args, " "), msg)
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGitChangedFiles(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		if _, err := runGit(dir, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	git("init", "-q")
+	git("config", "user.email", "test@example.com")
+	git("config", "user.name", "test")
+	write("a.go", "package a\n")
+	write("sub/b.go", "package sub\n")
+	git("add", "-A")
+	git("commit", "-q", "-m", "base")
+	git("tag", "base")
+
+	write("sub/b.go", "package sub\n\nvar x int\n")
+	write("sub/c.go", "package sub\n")
+	write("sub/README.md", "docs\n")
+	write("sub/d é.go", "package sub\n")
+	git("add", "-A")
+	git("commit", "-q", "-m", "change")
+
+	files, err := gitChangedFiles(filepath.Join(dir, "sub"), "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"b.go", "c.go", "d é.go"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("gitChangedFiles() = %v, want %v", files, want)
+	}
+
+	if _, err := gitChangedFiles(dir, "no-such-ref"); err == nil {
+		t.Errorf("expected error for an invalid ref")
+	}
+	if _, err := gitChangedFiles(dir, "--output=x"); err == nil || !strings.Contains(err.Error(), "invalid base") {
+		t.Errorf("got error %v for a base starting with -, want invalid base", err)
+	}
+}
+
+func TestWithinPaths(t *testing.T) {
+	files, err := withinPaths([]string{"a.go", filepath.Join("sub", "b.go"), filepath.Join("subdir", "c.go")}, []string{"sub", "a.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.go", filepath.Join("sub", "b.go")}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("withinPaths() = %v, want %v", files, want)
+	}
+}
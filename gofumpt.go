@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os/exec"
+)
+
+// formatOutput, if not nil, formats the rewritten files after gofmt
+// and the import fixer, for -fmt.
+var formatOutput func(src []byte) ([]byte, error)
+
+// gofumpt formats src with the stricter rules of gofumpt, running the
+// gofumpt command found in PATH, for -fmt gofumpt.
+func gofumpt(src []byte) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("gofumpt")
+	cmd.Stdin = bytes.NewReader(src)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			return nil, fmt.Errorf("gofumpt: %v: %s", err, msg)
+		}
+		return nil, fmt.Errorf("gofumpt: %v", err)
+	}
+	return out, nil
+}
+
+// printerFormat returns a formatOutput printing the files with cfg,
+// for the tab width and spaces settings of -tabwidth and -use-spaces.
+func printerFormat(cfg *printer.Config) func(src []byte) ([]byte, error) {
+	return func(src []byte) ([]byte, error) {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := cfg.Fprint(&buf, fset, f); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+
+	"golang.org/x/tools/imports"
+)
+
+// importFixer sorts and groups the imports of the rewritten source
+// src. The rules add and delete the imports themselves.
+type importFixer func(filename string, src []byte) ([]byte, error)
+
+// fixImports is the importFixer used by Rewrite, formatImports unless
+// -gopath-mode is given.
+var fixImports importFixer = formatImports
+
+// keepImports leaves the imports of the rewritten packages in place,
+// even if unused, for another tool to clean them up.
+var keepImports = false
+
+// renameExistingAlias renames the existing imports of the targets to
+// their alias, like utilnet "k8s.io/utils/net" to netutils, rewriting
+// their references. If false, the name they are imported as is kept,
+// and used for the rewritten references.
+var renameExistingAlias = true
+
+// existingAlias returns the name path is explicitly imported as in f,
+// or "" if it is not, or only as _ or ..
+func existingAlias(f *ast.File, path string) string {
+	for _, s := range importSpecs(f, path) {
+		if s.Name != nil && s.Name.Name != "_" && s.Name.Name != "." {
+			return s.Name.Name
+		}
+	}
+	return ""
+}
+
+// formatImports is the default importFixer, based on goimports. It
+// only sorts and groups the imports, without deleting any: it never
+// resolves the packages, that would search the module cache and GOPATH
+// for the unresolved names.
+func formatImports(filename string, src []byte) ([]byte, error) {
+	return imports.Process("", src, &imports.Options{
+		Comments:   true,
+		TabIndent:  true,
+		TabWidth:   8,
+		FormatOnly: true,
+	})
+}
+
+// astutilImports is an importFixer that does not depend on goimports,
+// for GOPATH projects where its module assumptions do not hold. The
+// rules already delete the imports of their sources once unused, so it
+// deletes none: guessing whether the others are used from the last
+// element of their path breaks imports like gopkg.in/yaml.v3. It only
+// sorts the imports and leaves goimports to group them.
+func astutilImports(filename string, src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parserMode)
+	if err != nil {
+		return nil, err
+	}
+	ast.SortImports(fset, f)
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, f); err != nil {
+		return nil, err
+	}
+	return formatImports(filename, buf.Bytes())
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// literals holds the positions of the string literals passed to the
+// functions of the rules, by value, for -collect-literals.
+var literals = make(map[string][]token.Position)
+
+// collectLiterals records in literals the string literals passed to
+// the functions of rules in the Go source src, read from filename.
+// The calls with other arguments are ignored.
+func collectLiterals(src []byte, filename string, rules []Rule) error {
+	f, err := parser.ParseFile(fset, filename, src, parserMode)
+	if err != nil {
+		return err
+	}
+	byName := rulesByName(f, rules)
+	if len(byName) == 0 {
+		return nil
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) != 1 {
+			return true
+		}
+		if _, r := ruleSelector(call.Fun, byName); r == nil {
+			return true
+		}
+		lit, ok := call.Args[0].(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			return true
+		}
+		s, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			return true
+		}
+		literals[s] = append(literals[s], position(lit.Pos()))
+		return true
+	})
+	return nil
+}
+
+// printLiterals prints the collected literals to w, sorted, each with
+// the positions it is passed at, like
+//
+//	"010.0.0.1" a.go:12:19, b.go:3:22
+func printLiterals(w io.Writer) {
+	values := make([]string, 0, len(literals))
+	for s := range literals {
+		values = append(values, s)
+	}
+	sort.Strings(values)
+	for _, s := range values {
+		pos := make([]string, len(literals[s]))
+		for i, p := range literals[s] {
+			pos[i] = p.String()
+		}
+		fmt.Fprintf(w, "%q %s\n", s, strings.Join(pos, ", "))
+	}
+}
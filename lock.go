@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// lockName is the name of the lock file of -apply-once.
+const lockName = ".sloppy-netparser.lock"
+
+// releaseLock releases the lock acquired by -apply-once, if not nil.
+var releaseLock func()
+
+// acquireLock creates the lock file in dir, holding the process ID,
+// so two runs over the same tree do not interleave their writes. It
+// fails fast if the file already exists, and the returned function
+// removes it. The lock is best effort: a run killed before releasing
+// it leaves the file behind, to remove by hand.
+func acquireLock(dir string) (release func(), err error) {
+	path := filepath.Join(dir, lockName)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		holder := "another run"
+		if b, err := os.ReadFile(path); err == nil && len(bytes.TrimSpace(b)) > 0 {
+			holder = fmt.Sprintf("the run of process %s", bytes.TrimSpace(b))
+		}
+		return nil, fmt.Errorf("%s: the tree is locked by %s; if it is not running anymore, remove the file", path, holder)
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return func() { os.Remove(path) }, nil
+}
+
+// lockDir returns the directory -apply-once locks for paths: the first
+// of them, or the directory of the first file, or the current
+// directory if there are none.
+func lockDir(paths []string) string {
+	if len(paths) == 0 {
+		return "."
+	}
+	if fi, err := os.Stat(paths[0]); err == nil && fi.IsDir() {
+		return paths[0]
+	}
+	return filepath.Dir(paths[0])
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAcquireLock(t *testing.T) {
+	dir := t.TempDir()
+	held := make(chan func())
+	go func() {
+		release, err := acquireLock(dir)
+		if err != nil {
+			t.Error(err)
+		}
+		held <- release
+	}()
+	release := <-held
+	if release == nil {
+		t.FailNow()
+	}
+
+	_, err := acquireLock(dir)
+	want := fmt.Sprintf("%s: the tree is locked by the run of process %d", filepath.Join(dir, lockName), os.Getpid())
+	if err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Fatalf("got error %v, want %q", err, want)
+	}
+
+	release()
+	release, err = acquireLock(dir)
+	if err != nil {
+		t.Fatalf("the lock is not released: %v", err)
+	}
+	release()
+}
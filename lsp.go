@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"sort"
+)
+
+// The subset of the LSP WorkspaceEdit type describing the edits of
+// the files, for -lsp-edits.
+type (
+	lspWorkspaceEdit struct {
+		DocumentChanges []lspDocumentChange `json:"documentChanges"`
+	}
+	lspDocumentChange struct {
+		TextDocument struct {
+			URI string `json:"uri"`
+			// Version is null: the edits apply to the files on disk.
+			Version *int `json:"version"`
+		} `json:"textDocument"`
+		Edits []lspTextEdit `json:"edits"`
+	}
+	lspTextEdit struct {
+		Range   lspRange `json:"range"`
+		NewText string   `json:"newText"`
+	}
+	lspRange struct {
+		Start lspPosition `json:"start"`
+		End   lspPosition `json:"end"`
+	}
+	lspPosition struct {
+		Line      int `json:"line"`
+		Character int `json:"character"`
+	}
+)
+
+// documentChanges holds the changes of the files, for -lsp-edits.
+var documentChanges []lspDocumentChange
+
+// addDocumentChange records the edits of res, made to the source src
+// of the file path, in documentChanges.
+func addDocumentChange(path string, src []byte, res Result) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	src = bytes.TrimPrefix(src, utf8BOM)
+	var c lspDocumentChange
+	c.TextDocument.URI = (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+	for _, e := range res.edits {
+		c.Edits = append(c.Edits, lspTextEdit{
+			Range:   lspRange{lspOffset(src, e.start), lspOffset(src, e.end)},
+			NewText: e.text,
+		})
+	}
+	documentChanges = append(documentChanges, c)
+	return nil
+}
+
+// lspOffset returns the LSP position of the byte offset off in src:
+// the lines start at 0 and the characters are UTF-16 code units.
+func lspOffset(src []byte, off int) lspPosition {
+	var p lspPosition
+	line := 0
+	if i := bytes.LastIndexByte(src[:off], '\n'); i >= 0 {
+		p.Line = bytes.Count(src[:off], []byte("\n"))
+		line = i + 1
+	}
+	for _, r := range string(src[line:off]) {
+		p.Character++
+		if r >= 0x10000 {
+			// A surrogate pair.
+			p.Character++
+		}
+	}
+	return p
+}
+
+// printWorkspaceEdit prints documentChanges to w as a WorkspaceEdit,
+// the files sorted by URI.
+func printWorkspaceEdit(w io.Writer) error {
+	sort.Slice(documentChanges, func(i, j int) bool {
+		return documentChanges[i].TextDocument.URI < documentChanges[j].TextDocument.URI
+	})
+	edit := lspWorkspaceEdit{DocumentChanges: documentChanges}
+	if edit.DocumentChanges == nil {
+		edit.DocumentChanges = []lspDocumentChange{}
+	}
+	data, err := json.MarshalIndent(edit, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
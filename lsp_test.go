@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestLSPOffset(t *testing.T) {
+	src := []byte("a\nbé😀c\n")
+	tests := []struct {
+		off  int
+		want lspPosition
+	}{
+		{0, lspPosition{0, 0}},
+		{1, lspPosition{0, 1}},
+		{2, lspPosition{1, 0}},
+		{3, lspPosition{1, 1}},
+		{5, lspPosition{1, 2}},
+		{9, lspPosition{1, 4}},
+		{11, lspPosition{2, 0}},
+	}
+	for _, tt := range tests {
+		if got := lspOffset(src, tt.off); got != tt.want {
+			t.Errorf("lspOffset(%d) = %+v, want %+v", tt.off, got, tt.want)
+		}
+	}
+}
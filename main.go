@@ -7,66 +7,427 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"go/ast"
 	"go/format"
 	"go/parser"
+	"go/printer"
 	"go/scanner"
 	"go/token"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+)
+
+var (
+	fset      = token.NewFileSet()
+	exitCode  = 0
+	diffFound = false
 
-	"golang.org/x/tools/imports"
+	// patch accumulates the changes written by -patch.
+	patch bytes.Buffer
+	// results accumulates the results printed by -json and -dry-summary.
+	results []Result
+	// remaining counts the calls left by function name, for
+	// -summary-threshold and -prom.
+	remaining = make(map[string]int)
 )
 
 var (
-	fset     = token.NewFileSet()
-	exitCode = 0
+	doDiff           = flag.Bool("diff", false, "display diffs instead of rewriting files")
+	diffExitCode     = flag.Bool("diff-exit-code", true, "with -diff, exit with status 3 if any file differs")
+	gitBase          = flag.String("git", "", "only process the Go files changed between `BASE` and HEAD")
+	verbose          = flag.Bool("v", false, "report the calls skipped by //sloppy:ignore directives and the skipped generated files")
+	gopath           = flag.Bool("gopath-mode", false, "fix imports without goimports package resolution, for GOPATH projects")
+	trim             = flag.String("trim-path", "", "strip `PREFIX` from the reported paths")
+	dump             = flag.Bool("dump-ast", false, "debug: print the AST and the imports of a single file to stderr")
+	tags             = flag.String("tags", "", "only rewrite the files matching the comma separated build `TAGS`, like \"linux,!cgo\", and report the others")
+	includeGenerated = flag.Bool("include-generated", false, "also rewrite the files marked as generated code")
+	list             = flag.Bool("l", false, "list the files that would be rewritten instead of rewriting them")
+	patchFile        = flag.String("patch", "", "write a single patch of all the changes to `FILE`, with the paths made relative by -trim-path, instead of rewriting files")
+	applyFromSARIF   = flag.String("apply-from-sarif", "", "apply the fixes of the SARIF log `FILE` to the files it refers to")
+	jsonOut          = flag.Bool("json", false, "print the results of the files that would be rewritten as a JSON array instead of rewriting them")
+	keepNetImport    = flag.Bool("keep-net-import", false, "rewrite the calls but never remove the net import, even if unused, leaving it to another tool")
+	verifyCompile    = flag.Bool("fix-and-verify-compile", false, "type-check the rewritten packages before writing them, and leave the packages that would not compile untouched")
+	showProgress     = flag.Bool("progress", false, "show the number of files processed when stderr is a terminal, except with -diff, -json and -jsonl")
+	maxErrors        = flag.Int("max-errors", 0, "stop after `N` errors, if positive")
+	showSummary      = flag.Bool("summary", false, "print to stderr whether each package is fully migrated, and the packages importing k8s.io/utils/net under several names")
+	assumeYes        = flag.Bool("assume-yes", false, "with -fix-and-verify-compile, write the packages that would not compile anyway, to fix them by hand")
+	reportWrappers   = flag.Bool("wrappers", false, "report the functions only returning the result of a rewritten call, and their callers in the file, that now get the sloppy parsers too")
+	drySummary       = flag.Bool("dry-summary", false, "print the number of files and calls that would be rewritten, and of the imports that would be added and removed, instead of rewriting files")
+	timeout          = flag.Duration("timeout", 0, "stop the run after `DURATION`, if positive, and report the files not processed")
+	showLiterals     = flag.Bool("collect-literals", false, "print the distinct string literals passed to the parsers, with their positions, instead of rewriting files")
+	formatter        = flag.String("fmt", "gofmt", "format the rewritten files with `FORMATTER`: gofmt, or gofumpt run from PATH")
+	noFormatFlag     = flag.Bool("no-format", false, "only edit the rewritten references and the imports, leaving the rest of the files as is, even if not gofmt clean")
+	reportRisky      = flag.Bool("report-only-risky", false, "print the calls to the parsers whose literal argument is parsed differently by the sloppy parsers, and the ones with other arguments, instead of rewriting files")
+	followSymlinks   = flag.Bool("follow-symlinks", false, "walk the symlinks to directories too, processing each file once")
+	lspEdits         = flag.Bool("lsp-edits", false, "print the edits of the files as an LSP WorkspaceEdit, in JSON, instead of rewriting them; implies -no-format")
+	manifestFile     = flag.String("manifest", "", "write to `FILE` the path of each rewritten file, with the SHA-256 hashes of its content before and after")
+	summaryOnly      = flag.Bool("summary-only", false, "print only the total numbers of files and calls rewritten to stderr, instead of a line per file")
+	renameAliasFlag  = flag.Bool("rename-existing-alias", true, "rename an existing import of the target package to its alias, like utilnet to netutils; false keeps the name it is imported as, and names the new imports of the package like it")
+	readAheadFlag    = flag.Int("read-ahead", 0, "read up to `N` files ahead of the one being rewritten, concurrently, for slow storage like network filesystems; the files are still rewritten one at a time")
+	dumpRules        = flag.Bool("dump-rules", false, "print the rules applied, the built-in ones and the ones of -also-from, as a table sorted by package and name, instead of rewriting files")
+	onError          = flag.String("on-error", "continue", "the `POLICY` after an error: continue, to process all the files and report all the errors, or stop, at the first one, like -max-errors 1, which it excludes")
+	summaryThreshold = flag.Int("summary-threshold", -1, "count the calls to the strict parsers left, instead of rewriting files, and exit with status 3 if there are more than `N`, if not negative")
+	promFile         = flag.String("prom", "", "write the numbers of calls to the strict parsers left to `FILE`, in the Prometheus text format of the node exporter textfile collector, instead of rewriting files")
+	applyOnce        = flag.Bool("apply-once", false, "lock the tree while the run lasts, with a "+lockName+" file in the first directory given, and fail if another run holds the lock")
+	tabWidth         = flag.Int("tabwidth", 8, "print the rewritten files with tabs `N` columns wide, instead of 8 like gofmt, for the alignment of the comments and fields")
+	useSpaces        = flag.Bool("use-spaces", false, "indent the rewritten files with spaces, -tabwidth per level, instead of tabs")
+	jsonLines        = flag.Bool("jsonl", false, "print the result of each file that would be rewritten as a line of JSON, as soon as processed, instead of rewriting them")
+	sortLines        = flag.Bool("sort", false, "with -jsonl, print the lines at the end, sorted by file name")
 )
 
-var doDiff = flag.Bool("diff", false, "display diffs instead of rewriting files")
+// excludeDirs holds the names of the directories -exclude-dir skips.
+var excludeDirs = dirNames{"vendor", "testdata"}
+
+// alsoFromPaths holds the import paths of the -also-from packages.
+var alsoFromPaths importPaths
+
+// promLabelSet holds the labels -label adds to the -prom metrics.
+var promLabelSet = promLabels{}
+
+// ignoreFiles holds the paths of the files -ignore-file leaves alone.
+var ignoreFiles filePaths
+
+func init() {
+	flag.Var(&alsoFromPaths, "also-from", "rewrite the ParseIP and ParseCIDR functions of the package `PATH` too, a wrapper of the strict net parsers; may be repeated")
+	flag.Var(promLabelSet, "label", "add the label `KEY=VALUE` to the -prom metrics, like repo=kubernetes; may be repeated")
+	flag.Var(&ignoreFiles, "ignore-file", "never rewrite the file at `PATH`, even if given as an argument; may be repeated")
+	flag.Var(&excludeDirs, "exclude-dir", "skip the directories called `NAME` when walking the trees, in addition to vendor and testdata; may be repeated, and an empty NAME clears the list")
+}
+
+// dirNames is a flag.Value collecting directory names, one per flag.
+type dirNames []string
+
+func (d *dirNames) String() string { return strings.Join(*d, ",") }
+
+func (d *dirNames) Set(name string) error {
+	if name == "" {
+		*d = nil
+		return nil
+	}
+	if strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("%q is not a directory name", name)
+	}
+	*d = append(*d, name)
+	return nil
+}
+
+// importPaths is a flag.Value collecting import paths, one per flag.
+type importPaths []string
+
+func (p *importPaths) String() string { return strings.Join(*p, ",") }
+
+func (p *importPaths) Set(path string) error {
+	if path == "" {
+		return errors.New("empty import path")
+	}
+	*p = append(*p, path)
+	return nil
+}
+
+// filePaths is a flag.Value collecting file paths, one per flag.
+type filePaths []string
+
+func (p *filePaths) String() string { return strings.Join(*p, ",") }
+
+func (p *filePaths) Set(path string) error {
+	if path == "" {
+		return errors.New("empty file path")
+	}
+	*p = append(*p, path)
+	return nil
+}
 
 // enable for debugging fix failures
 const debug = false // display incorrectly reformatted source and exit
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "usage: sloppy-netparser [-diff] [path ...]\n")
+	fmt.Fprintf(os.Stderr, "usage: sloppy-netparser [flags] [path ...]\n")
+	fmt.Fprintf(os.Stderr, "       sloppy-netparser command [flags] path ...\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-6s  %s\n", c.name, c.help)
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
 	flag.PrintDefaults()
 	os.Exit(2)
 }
 
+// exit exits with the status of the run: 2 if there were errors,
+// 3 if -diff displayed any differences or check found files to
+// rewrite, 0 otherwise.
+func exit() {
+	if releaseLock != nil {
+		releaseLock()
+	}
+	if exitCode == 0 && diffFound && *diffExitCode {
+		exitCode = 3
+	}
+	os.Exit(exitCode)
+}
+
 func main() {
 	flag.Usage = usage
 	flag.Parse()
+	args := flag.Args()
+	if len(args) > 0 {
+		if c := lookupCommand(args[0]); c != nil {
+			args = c.parse(args[1:])
+		}
+	}
 
-	if flag.NArg() == 0 {
-		if err := processFile("standard input", true); err != nil {
+	if *gopath {
+		fixImports = astutilImports
+	}
+	renameExistingAlias = *renameAliasFlag
+	if *keepNetImport {
+		keepImports = true
+		fixImports = formatImports
+	}
+	switch *formatter {
+	case "gofmt":
+	case "gofumpt":
+		formatOutput = gofumpt
+	default:
+		fmt.Fprintf(os.Stderr, "-fmt: unknown formatter %q, want gofmt or gofumpt\n", *formatter)
+		os.Exit(2)
+	}
+	switch *onError {
+	case "continue":
+	case "stop":
+		if *maxErrors != 0 {
+			fmt.Fprintln(os.Stderr, "-on-error stop excludes -max-errors")
+			os.Exit(2)
+		}
+		*maxErrors = 1
+	default:
+		fmt.Fprintf(os.Stderr, "-on-error: unknown policy %q, want continue or stop\n", *onError)
+		os.Exit(2)
+	}
+	if *sortLines && !*jsonLines {
+		fmt.Fprintln(os.Stderr, "-sort requires -jsonl")
+		os.Exit(2)
+	}
+	if *jsonLines && *jsonOut {
+		fmt.Fprintln(os.Stderr, "-jsonl excludes -json")
+		os.Exit(2)
+	}
+	if (*jsonOut || *drySummary) && (*doDiff || *list || *patchFile != "" || *verifyCompile) {
+		fmt.Fprintln(os.Stderr, "-json and -dry-summary exclude -diff, -l, -patch and -fix-and-verify-compile")
+		os.Exit(2)
+	}
+	if (*showLiterals || *reportRisky) && (*doDiff || *list || *patchFile != "" || *verifyCompile) {
+		fmt.Fprintln(os.Stderr, "-collect-literals and -report-only-risky exclude -diff, -l, -patch and -fix-and-verify-compile")
+		os.Exit(2)
+	}
+	if *summaryThreshold >= 0 && (*jsonOut || *jsonLines) {
+		fmt.Fprintln(os.Stderr, "-summary-threshold excludes -json and -jsonl")
+		os.Exit(2)
+	}
+	if (*summaryThreshold >= 0 || *promFile != "") && (*doDiff || *list || *patchFile != "" || *verifyCompile) {
+		fmt.Fprintln(os.Stderr, "-summary-threshold and -prom exclude -diff, -l, -patch and -fix-and-verify-compile")
+		os.Exit(2)
+	}
+	if *tabWidth != 8 || *useSpaces {
+		if formatOutput != nil || *noFormatFlag || *lspEdits {
+			fmt.Fprintln(os.Stderr, "-tabwidth and -use-spaces exclude -fmt gofumpt, -no-format and -lsp-edits")
+			os.Exit(2)
+		}
+		if *tabWidth < 1 {
+			fmt.Fprintf(os.Stderr, "-tabwidth: invalid width %d\n", *tabWidth)
+			os.Exit(2)
+		}
+		mode := printer.UseSpaces | printer.TabIndent
+		if *useSpaces {
+			mode = printer.UseSpaces
+		}
+		formatOutput = printerFormat(&printer.Config{Mode: mode, Tabwidth: *tabWidth})
+	}
+	if *noFormatFlag || *lspEdits {
+		if formatOutput != nil {
+			fmt.Fprintf(os.Stderr, "-no-format and -lsp-edits exclude -fmt %s\n", *formatter)
+			os.Exit(2)
+		}
+		noFormat = true
+	}
+
+	if *dump {
+		if flag.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "-dump-ast requires a single file")
+			os.Exit(2)
+		}
+		if fi, err := os.Stat(flag.Arg(0)); err == nil && fi.IsDir() {
+			fmt.Fprintln(os.Stderr, "-dump-ast does not support directories")
+			os.Exit(2)
+		}
+		if err := dumpAST(os.Stderr, flag.Arg(0)); err != nil {
 			report(err)
 		}
-		os.Exit(exitCode)
+		exit()
 	}
 
-	for i := 0; i < flag.NArg(); i++ {
-		path := flag.Arg(i)
-		switch dir, err := os.Stat(path); {
-		case err != nil:
+	if *applyFromSARIF != "" {
+		if err := applySARIF(*applyFromSARIF); err != nil {
 			report(err)
-		case dir.IsDir():
-			if err := walkDir(path); err != nil {
-				report(err)
-			}
-		default:
-			if err := processFile(path, false); err != nil {
+		}
+		exit()
+	}
+
+	if *manifestFile != "" {
+		manifest = make(map[string]manifestEntry)
+	}
+
+	cfg := Config{
+		Tags:             *tags,
+		IncludeGenerated: *includeGenerated,
+		TrimPath:         *trim,
+		MaxErrors:        *maxErrors,
+		FollowSymlinks:   *followSymlinks,
+		ExcludeDirs:      excludeDirs,
+		ReadAhead:        *readAheadFlag,
+		IgnoreFiles:      ignoreFiles,
+	}
+	if len(alsoFromPaths) > 0 {
+		cfg.Rules = alsoFrom(sloppyRules, alsoFromPaths)
+	}
+	if *dumpRules {
+		rules := cfg.Rules
+		if rules == nil {
+			rules = sloppyRules
+		}
+		if err := printRules(os.Stdout, rules); err != nil {
+			report(err)
+		}
+		exit()
+	}
+
+	paths := args
+	if *gitBase != "" {
+		dir, err := os.Getwd()
+		var files []string
+		if err == nil {
+			files, err = gitChangedFiles(dir, *gitBase)
+		}
+		if err == nil && len(paths) > 0 {
+			files, err = withinPaths(files, paths)
+		}
+		paths = files
+		if err != nil {
+			report(err)
+			exit()
+		}
+	} else if len(paths) == 0 {
+		if err := processStdin(); err != nil {
+			report(err)
+		}
+		exit()
+	}
+
+	if *applyOnce {
+		release, err := acquireLock(lockDir(paths))
+		if err != nil {
+			report(err)
+			exit()
+		}
+		releaseLock = release
+	}
+
+	fn := fixFile
+	var p *progress
+	if *showProgress && !*doDiff && !*jsonOut && !*jsonLines && isTerminal(os.Stderr) {
+		p = &progress{w: os.Stderr, total: countGoFiles(paths, cfg)}
+		fn = p.wrap(fn)
+	}
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	err := WalkAndFixContext(ctx, paths, cfg, fn)
+	if p != nil {
+		p.clear()
+	}
+	if err != nil {
+		report(err)
+	}
+	if *showSummary {
+		printSummary(os.Stderr)
+	}
+	if *verifyCompile {
+		if err := writeVerified(pending, *assumeYes); err != nil {
+			report(err)
+		}
+	}
+	if *patchFile != "" {
+		if err := os.WriteFile(*patchFile, patch.Bytes(), 0644); err != nil {
+			report(err)
+		}
+	}
+	if *drySummary {
+		printDrySummary(os.Stdout, results)
+	}
+	if *summaryOnly {
+		printTotals(os.Stderr, results, !*list && !*doDiff && *patchFile == "")
+	}
+	if *summaryThreshold >= 0 {
+		total := 0
+		for _, n := range remaining {
+			total += n
+		}
+		fmt.Fprintf(os.Stderr, "%s to the strict parsers left, at most %d allowed\n", plural(total, "call"), *summaryThreshold)
+		if total > *summaryThreshold && exitCode == 0 {
+			exitCode = 3
+		}
+	}
+	if *promFile != "" {
+		rules := cfg.Rules
+		if rules == nil {
+			rules = sloppyRules
+		}
+		if err := writeFileAtomic(*promFile, promMetrics(rules, remaining, promLabelSet), 0644); err != nil {
+			report(err)
+		}
+	}
+	if *showLiterals {
+		printLiterals(os.Stdout)
+	}
+	if *reportRisky {
+		printRisky(os.Stdout)
+	}
+	if *jsonOut {
+		if err := printJSON(results); err != nil {
+			report(err)
+		}
+	}
+	if *jsonLines && *sortLines {
+		sort.Slice(results, func(i, j int) bool { return results[i].Filename < results[j].Filename })
+		for _, res := range results {
+			if err := printJSONLine(res); err != nil {
 				report(err)
+				break
 			}
 		}
 	}
+	if *lspEdits {
+		if err := printWorkspaceEdit(os.Stdout); err != nil {
+			report(err)
+		}
+	}
+	if *manifestFile != "" {
+		if err := writeManifest(*manifestFile); err != nil {
+			report(err)
+		}
+	}
 
-	os.Exit(exitCode)
+	exit()
 }
 
 const parserMode = parser.ParseComments
@@ -79,131 +440,273 @@ func gofmtFile(f *ast.File) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func processFile(filename string, useStdin bool) error {
-	var f *os.File
-	var err error
-	var fixlog bytes.Buffer
-
-	if useStdin {
-		f = os.Stdin
-	} else {
-		f, err = os.Open(filename)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-	}
-
-	src, err := io.ReadAll(f)
+// processStdin rewrites the standard input to the standard output.
+func processStdin() error {
+	src, err := io.ReadAll(os.Stdin)
 	if err != nil {
 		return err
 	}
-
-	file, err := parser.ParseFile(fset, filename, src, parserMode)
+	newSrc, res, err := rewrite(src, "standard input", alsoFrom(sloppyRules, alsoFromPaths))
 	if err != nil {
 		return err
 	}
+	reportResult(res)
+	if !res.Changed() {
+		return nil
+	}
+	if *doDiff {
+		return printDiff(res.Filename, src, newSrc)
+	}
+	_, err = os.Stdout.Write(newSrc)
+	return err
+}
 
-	// Make sure file is in canonical format.
-	// This "fmt" pseudo-fix cannot be disabled.
-	newSrc, err := gofmtFile(file)
-	if err != nil {
-		return err
+// fixFile is the WalkAndFix callback of the command: it lists,
+// diffs or writes the rewritten file, depending on the flags.
+func fixFile(path string, res Result, out []byte) error {
+	if *showSummary {
+		summarize(res, out)
 	}
-	if !bytes.Equal(newSrc, src) {
-		newFile, err := parser.ParseFile(fset, filename, newSrc, parserMode)
+	if *showLiterals || *reportRisky {
+		if res.Generated || res.NotGo {
+			return nil
+		}
+		src, err := os.ReadFile(path)
 		if err != nil {
 			return err
 		}
-		file = newFile
-		fmt.Fprintf(&fixlog, " fmt")
+		if *reportRisky {
+			return collectRisky(src, res.Filename, sloppyRules)
+		}
+		return collectLiterals(src, res.Filename, sloppyRules)
 	}
-
-	// Apply all fixes to file.
-	newFile := file
-	fixed := false
-
-	if sloppyParsers(newFile) {
-		fixed = true
-		fmt.Fprintf(&fixlog, " %s", "sloppy-netparsers")
-
-		// AST changed.
-		// Print and parse, to update any missing scoping
-		// or position information for subsequent fixers.
-		newSrc, err := gofmtFile(newFile)
+	if *lspEdits {
+		if !res.Changed() || res.Excluded {
+			return nil
+		}
+		src, err := os.ReadFile(path)
 		if err != nil {
 			return err
 		}
-		newFile, err = parser.ParseFile(fset, filename, newSrc, parserMode)
+		return addDocumentChange(path, src, res)
+	}
+	if *summaryThreshold >= 0 || *promFile != "" {
+		for name, n := range res.Calls {
+			remaining[name] += n
+		}
+		return nil
+	}
+	if *jsonLines {
+		if !res.Changed() {
+			return nil
+		}
+		if *sortLines {
+			results = append(results, res)
+			return nil
+		}
+		return printJSONLine(res)
+	}
+	if *jsonOut || *drySummary {
+		if res.Changed() && !(*drySummary && res.Excluded) {
+			results = append(results, res)
+		}
+		return nil
+	}
+	if *summaryOnly {
+		if res.Changed() && !res.Excluded {
+			results = append(results, res)
+		}
+	} else {
+		reportResult(res)
+	}
+	if !res.Changed() || res.Excluded {
+		return nil
+	}
+	switch {
+	case *list:
+		if !*summaryOnly {
+			fmt.Println(res.Filename)
+		}
+		if checkOnly {
+			diffFound = true
+		}
+		return nil
+	case *doDiff, *patchFile != "":
+		src, err := os.ReadFile(path)
 		if err != nil {
-			if debug {
-				fmt.Printf("%s", newSrc)
-				report(err)
-				os.Exit(exitCode)
-			}
 			return err
 		}
-	}
-	if !fixed {
+		if *doDiff {
+			return printDiff(res.Filename, src, out)
+		}
+		data, err := patchDiff(res.Filename, src, out)
+		if err != nil {
+			return fmt.Errorf("computing diff: %s", err)
+		}
+		patch.Write(data)
 		return nil
+	case *verifyCompile:
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		pending[abs] = out
+		return nil
+	}
+	return writeFile(path, out)
+}
+
+// writeFile replaces the content of the existing file path with src,
+// atomically, so an interrupted run does not leave it truncated: see
+// writeFileAtomic. The file keeps its mode, and a symlink is kept and
+// its target replaced. A read-only file is not replaced, though the
+// rename would succeed in a writable directory. The errors are
+// reported as "cannot write PATH: REASON".
+func writeFile(path string, src []byte) error {
+	var before []byte
+	if manifest != nil {
+		before, _ = os.ReadFile(path)
+	}
+	if err := replaceFile(path, src); err != nil {
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) {
+			err = pathErr.Err
+		}
+		return fmt.Errorf("cannot write %s: %w", path, err)
 	}
-	fmt.Fprintf(os.Stderr, "%s: fixed %s\n", filename, fixlog.String()[1:])
+	recordWrite(path, before, src)
+	return nil
+}
 
-	// Print AST.  We did that after each fix, so this appears
-	// redundant, but it is necessary to generate gofmt-compatible
-	// source code in a few cases. The official gofmt style is the
-	// output of the printer run on a standard AST generated by the parser,
-	// but the source we generated inside the loop above is the
-	// output of the printer run on a mangled AST generated by a fixer.
-	fmtSrc, err := gofmtFile(newFile)
+// replaceFile is the write of writeFile.
+func replaceFile(path string, src []byte) error {
+	real, err := filepath.EvalSymlinks(path)
 	if err != nil {
 		return err
 	}
-	// Fix imports, since it is possible that some of them are no longer required
-	newSrc, err = imports.Process("", fmtSrc, nil)
+	fi, err := os.Stat(real)
 	if err != nil {
 		return err
 	}
-	if *doDiff {
-		data, err := Diff("go-fix", src, newSrc)
-		if err != nil {
-			return fmt.Errorf("computing diff: %s", err)
-		}
-		fmt.Printf("diff %s fixed/%s\n", filename, filename)
-		os.Stdout.Write(data)
-		return nil
+	if fi.Mode().Perm()&0200 == 0 {
+		return &fs.PathError{Op: "write", Path: path, Err: fs.ErrPermission}
 	}
+	return writeFileAtomic(real, src, fi.Mode().Perm())
+}
 
-	if useStdin {
-		os.Stdout.Write(newSrc)
-		return nil
+// reportResult prints the summary and the warnings of res to stderr.
+func reportResult(res Result) {
+	if res.Generated {
+		if *verbose {
+			fmt.Fprintf(os.Stderr, "%s: skipped generated file\n", res.Filename)
+		}
+		return
+	}
+	if res.NotGo {
+		fmt.Fprintf(os.Stderr, "warning: %s: skipped, not a .go file\n", res.Filename)
+		return
+	}
+	for _, w := range res.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+	if *verbose {
+		for _, i := range res.Ignored {
+			fmt.Fprintln(os.Stderr, i)
+		}
+	}
+	if *reportWrappers {
+		for _, w := range res.Wrappers {
+			fmt.Fprintln(os.Stderr, w)
+			for _, pos := range w.Callers {
+				fmt.Fprintf(os.Stderr, "%s: calls %s\n", pos, w.Func)
+			}
+		}
+	}
+	switch {
+	case !res.Changed():
+	case res.Excluded:
+		fmt.Fprintf(os.Stderr, "%s (excluded by -tags, not modified)\n", res.String())
+	default:
+		fmt.Fprintln(os.Stderr, res.String())
 	}
-
-	return os.WriteFile(f.Name(), newSrc, 0)
 }
 
-func report(err error) {
-	scanner.PrintError(os.Stderr, err)
-	exitCode = 2
+// printJSON prints results as a JSON array, sorted by file name.
+func printJSON(results []Result) error {
+	sort.Slice(results, func(i, j int) bool { return results[i].Filename < results[j].Filename })
+	if results == nil {
+		results = []Result{}
+	}
+	data, err := json.MarshalIndent(results, "", "\t")
 	if err != nil {
-		panic(err)
+		return err
 	}
+	fmt.Printf("%s\n", data)
+	return nil
 }
 
-func walkDir(path string) error {
-	return filepath.WalkDir(path, visitFile)
+// printJSONLine prints res as a line of JSON, for -jsonl.
+func printJSONLine(res Result) error {
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", data)
+	return nil
 }
 
-func visitFile(path string, f fs.DirEntry, err error) error {
-	if err == nil && isGoFile(f) {
-		err = processFile(path, false)
-	}
+// printDiff prints the diff between the source of name and its rewrite.
+func printDiff(name string, src, out []byte) error {
+	data, err := differ.Diff(name, src, out)
 	if err != nil {
-		report(err)
+		return fmt.Errorf("computing diff: %s", err)
 	}
+	diffFound = true
+	fmt.Printf("diff %s fixed/%s\n", name, name)
+	os.Stdout.Write(data)
 	return nil
 }
 
+// trimPath returns path without the leading prefix, for reporting.
+// A relative path is made absolute before matching an absolute prefix.
+func trimPath(path, prefix string) string {
+	if prefix == "" {
+		return path
+	}
+	p := path
+	if filepath.IsAbs(prefix) && !filepath.IsAbs(p) {
+		if abs, err := filepath.Abs(p); err == nil {
+			p = abs
+		}
+	}
+	prefix = filepath.Clean(prefix)
+	if !strings.HasPrefix(p, prefix) {
+		return path
+	}
+	rest := p[len(prefix):]
+	switch {
+	case rest == "":
+		return "."
+	case os.IsPathSeparator(rest[0]):
+		return rest[1:]
+	case os.IsPathSeparator(prefix[len(prefix)-1]):
+		return rest
+	}
+	// prefix ends in the middle of a path element.
+	return path
+}
+
+func report(err error) {
+	if l, ok := err.(errorList); ok {
+		for _, err := range l {
+			scanner.PrintError(os.Stderr, err)
+		}
+	} else {
+		scanner.PrintError(os.Stderr, err)
+	}
+	exitCode = 2
+}
+
 func isGoFile(f fs.DirEntry) bool {
 	// ignore non-Go files
 	name := f.Name()
@@ -9,154 +9,1452 @@ import (
 	"go/parser"
 	"strings"
 	"testing"
+)
+
+type testCase struct {
+	Name string
+	Fn   func(*ast.File) bool
+	In   string
+	Out  string
+}
+
+var testCases = []testCase{
+	{
+		Name: "no change - only net",
+		In: `package main
+
+import "net"
+
+func f() net.Addr {
+	a := &net.IPAddr{ip1}
+	sub(&net.UDPAddr{ip2, 12345})
+	c := &net.TCPAddr{IP: ip3, Port: 54321}
+	d := &net.TCPAddr{ip4, 0}
+	p := 1234
+	e := &net.TCPAddr{ip4, p}
+	return &net.TCPAddr{ip5}, nil
+}
+`,
+		Out: `package main
+
+import "net"
+
+func f() net.Addr {
+	a := &net.IPAddr{ip1}
+	sub(&net.UDPAddr{ip2, 12345})
+	c := &net.TCPAddr{IP: ip3, Port: 54321}
+	d := &net.TCPAddr{ip4, 0}
+	p := 1234
+	e := &net.TCPAddr{ip4, p}
+	return &net.TCPAddr{ip5}, nil
+}
+`,
+	},
+	{
+		Name: "change net.ParseIP",
+		In: `package main
+
+import "net"
+
+func f() net.Addr {
+	a := &net.IPAddr{ip1}
+	c := net.ParseIP("ads")
+	return &net.TCPAddr{ip5}, nil
+}
+`,
+		Out: `package main
+
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+func f() net.Addr {
+	a := &net.IPAddr{ip1}
+	c := netutils.ParseIPSloppy("ads")
+	return &net.TCPAddr{ip5}, nil
+}
+`,
+	},
+	{
+		Name: "change net.ParseIP and ParseCIDR",
+		In: `package main
+
+import "net"
+
+func f() net.Addr {
+	a := &net.IPAddr{ip1}
+	c := net.ParseIP("ads")
+	d, _, err := net.ParseCIDR("ads")
+	return &net.TCPAddr{ip5}, nil
+}
+`,
+		Out: `package main
+
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+func f() net.Addr {
+	a := &net.IPAddr{ip1}
+	c := netutils.ParseIPSloppy("ads")
+	d, _, err := netutils.ParseCIDRSloppy("ads")
+	return &net.TCPAddr{ip5}, nil
+}
+`,
+	},
+	{
+		Name: "change net.ParseIP and ParseCIDR and remove net",
+		In: `package main
+
+import "net"
+
+func f() {
+	c := net.ParseIP("ads")
+	d, _, err := net.ParseCIDR("ads")
+}
+`,
+		Out: `package main
+
+import (
+	netutils "k8s.io/utils/net"
+)
+
+func f() {
+	c := netutils.ParseIPSloppy("ads")
+	d, _, err := netutils.ParseCIDRSloppy("ads")
+}
+`,
+	},
+	{
+		Name: "existing netutils and change net.ParseIP and ParseCIDR and remove net",
+		In: `package main
+
+import (
+	"net"
+
+	utilnet "k8s.io/utils/net"
+)
+
+func f() {
+	c := net.ParseIP("ads")
+	d, _, err := net.ParseCIDR("ads")
+	utilnet.IsIPv6(d)
+}
+`,
+		Out: `package main
+
+import (
+	netutils "k8s.io/utils/net"
+)
+
+func f() {
+	c := netutils.ParseIPSloppy("ads")
+	d, _, err := netutils.ParseCIDRSloppy("ads")
+	netutils.IsIPv6(d)
+}
+`,
+	},
+	{
+		Name: "ignore directives",
+		In: `package main
+
+import "net"
+
+func f() {
+	a := net.ParseIP("a") //sloppy:ignore
+	//sloppy:ignore(benchmark baseline)
+	b, _, err := net.ParseCIDR("b")
+	c := net.ParseIP("c")
+}
+`,
+		Out: `package main
+
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+func f() {
+	a := net.ParseIP("a") //sloppy:ignore
+	//sloppy:ignore(benchmark baseline)
+	b, _, err := net.ParseCIDR("b")
+	c := netutils.ParseIPSloppy("c")
+}
+`,
+	},
+	{
+		Name: "line directive",
+		In: `package main
+
+import "net"
+
+func f() {
+//line template.tmpl:100
+	c := net.ParseIP("ads")
+}
+`,
+		Out: `package main
+
+import (
+	netutils "k8s.io/utils/net"
+)
+
+func f() {
+//line template.tmpl:100
+	c := netutils.ParseIPSloppy("ads")
+}
+`,
+	},
+	{
+		Name: "no change - selectors not on the net package",
+		In: `package main
+
+import "net"
+
+type parser struct {
+	ParseIP func(string) net.IP
+}
+
+type wrapper struct {
+	net parser
+}
+
+func f(obj parser, pkg struct{ sub parser }, x wrapper) {
+	a := obj.ParseIP("a")
+	b := pkg.sub.ParseIP("b")
+	c := x.net.ParseIP("c")
+}
+
+func g(net parser) {
+	d := net.ParseIP("d")
+}
+
+func h() {
+	net := parser{}
+	e := net.ParseIP("e")
+}
+`,
+		Out: `package main
+
+import "net"
+
+type parser struct {
+	ParseIP func(string) net.IP
+}
+
+type wrapper struct {
+	net parser
+}
+
+func f(obj parser, pkg struct{ sub parser }, x wrapper) {
+	a := obj.ParseIP("a")
+	b := pkg.sub.ParseIP("b")
+	c := x.net.ParseIP("c")
+}
+
+func g(net parser) {
+	d := net.ParseIP("d")
+}
+
+func h() {
+	net := parser{}
+	e := net.ParseIP("e")
+}
+`,
+	},
+	{
+		Name: "change aliased net",
+		In: `package main
+
+import n "net"
+
+func f() {
+	c := n.ParseIP("ads")
+}
+`,
+		Out: `package main
+
+import (
+	netutils "k8s.io/utils/net"
+)
+
+func f() {
+	c := netutils.ParseIPSloppy("ads")
+}
+`,
+	},
+	{
+		Name: "change nested calls and value references",
+		In: `package main
+
+import "net"
+
+var parsers = []func(string) net.IP{net.ParseIP}
+
+func f(s string) {
+	parse := net.ParseIP
+	c := net.ParseIP(net.ParseIP(s).String())
+	g(net.ParseCIDR)
+}
+`,
+		Out: `package main
+
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+var parsers = []func(string) net.IP{netutils.ParseIPSloppy}
+
+func f(s string) {
+	parse := netutils.ParseIPSloppy
+	c := netutils.ParseIPSloppy(netutils.ParseIPSloppy(s).String())
+	g(netutils.ParseCIDRSloppy)
+}
+`,
+	},
+	{
+		Name: "change net.ParseCIDR discarding the network or returning it",
+		In: `package main
+
+import "net"
+
+func f(s string) net.IP {
+	ip, _, _ := net.ParseCIDR(s)
+	return ip
+}
+
+var parse = func(s string) (net.IP, *net.IPNet, error) {
+	return net.ParseCIDR(s)
+}
+`,
+		Out: `package main
+
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+func f(s string) net.IP {
+	ip, _, _ := netutils.ParseCIDRSloppy(s)
+	return ip
+}
+
+var parse = func(s string) (net.IP, *net.IPNet, error) {
+	return netutils.ParseCIDRSloppy(s)
+}
+`,
+	},
+	{
+		Name: "two target packages",
+		Fn: func(f *ast.File) bool {
+			return applyRules(f, []Rule{
+				sloppyRules[0],
+				{
+					Path:        "net",
+					Name:        "ParseCIDR",
+					TargetPath:  "example.com/mymod",
+					TargetName:  "ParseCIDRLenient",
+					TargetAlias: "mymod",
+				},
+			}, nil)
+		},
+		In: `package main
+
+import "net"
+
+func f() {
+	c := net.ParseIP("ads")
+	d, _, err := net.ParseCIDR("ads")
+}
+`,
+		Out: `package main
+
+import (
+	mymod "example.com/mymod"
+	netutils "k8s.io/utils/net"
+)
+
+func f() {
+	c := netutils.ParseIPSloppy("ads")
+	d, _, err := mymod.ParseCIDRLenient("ads")
+}
+`,
+	},
+	{
+		Name: "change selectors on the function values",
+		In: `package main
+
+import "net"
+
+func f() {
+	s := net.ParseIP.String
+	net.ParseCIDR.Foo()
+}
+`,
+		Out: `package main
+
+import (
+	netutils "k8s.io/utils/net"
+)
+
+func f() {
+	s := netutils.ParseIPSloppy.String
+	netutils.ParseCIDRSloppy.Foo()
+}
+`,
+	},
+	{
+		Name: "change calls in index expressions",
+		In: `package main
+
+import "net"
+
+func f(addrs map[string]int, m map[string]bool, ips []int, s string) {
+	a := addrs[net.ParseIP(s).String()]
+	m[net.ParseIP(s).String()] = true
+	_, n, _ := net.ParseCIDR(s)
+	b := ips[len(net.ParseIP(s))]
+	c := ips[:len(n.Mask)]
+	d := map[string]bool{}[func() string { _, n, _ := net.ParseCIDR(s); return n.String() }()]
+}
+`,
+		Out: `package main
+
+import (
+	netutils "k8s.io/utils/net"
+)
+
+func f(addrs map[string]int, m map[string]bool, ips []int, s string) {
+	a := addrs[netutils.ParseIPSloppy(s).String()]
+	m[netutils.ParseIPSloppy(s).String()] = true
+	_, n, _ := netutils.ParseCIDRSloppy(s)
+	b := ips[len(netutils.ParseIPSloppy(s))]
+	c := ips[:len(n.Mask)]
+	d := map[string]bool{}[func() string { _, n, _ := netutils.ParseCIDRSloppy(s); return n.String() }()]
+}
+`,
+	},
+	{
+		Name: "no change - net selector without the net import",
+		In: `package main
+
+import net "example.com/net"
+
+func f() {
+	c := net.ParseIP("ads")
+}
+`,
+		Out: `package main
+
+import net "example.com/net"
+
+func f() {
+	c := net.ParseIP("ads")
+}
+`,
+	},
+	{
+		Name: "change duplicated net imports",
+		In: `package main
+
+import (
+	"net"
+	n "net"
+)
+
+func f() {
+	a := net.ParseIP("a")
+	b := n.ParseIP("b")
+}
+`,
+		Out: `package main
+
+import (
+	netutils "k8s.io/utils/net"
+)
+
+func f() {
+	a := netutils.ParseIPSloppy("a")
+	b := netutils.ParseIPSloppy("b")
+}
+`,
+	},
+	{
+		Name: "change function values assigned to fields",
+		In: `package main
+
+import "net"
+
+type config struct {
+	parse func(string) net.IP
+}
+
+func newConfig() *config {
+	cfg := &config{}
+	cfg.parse = net.ParseIP
+	return cfg
+}
+
+func (c *config) ip(s string) net.IP {
+	return c.parse(s)
+}
+`,
+		Out: `package main
+
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+type config struct {
+	parse func(string) net.IP
+}
+
+func newConfig() *config {
+	cfg := &config{}
+	cfg.parse = netutils.ParseIPSloppy
+	return cfg
+}
+
+func (c *config) ip(s string) net.IP {
+	return c.parse(s)
+}
+`,
+	},
+	{
+		Name: "change with the netutils names taken",
+		In: `package main
+
+import (
+	"net"
+
+	netutils "example.com/a/netutils"
+	netutils2 "example.com/b/netutils"
+)
+
+var _, _ = netutils.X, netutils2.X
+
+func f() net.IP {
+	return net.ParseIP("a")
+}
+`,
+		Out: `package main
+
+import (
+	"net"
+
+	netutils "example.com/a/netutils"
+	netutils2 "example.com/b/netutils"
+	netutils3 "k8s.io/utils/net"
+)
+
+var _, _ = netutils.X, netutils2.X
+
+func f() net.IP {
+	return netutils3.ParseIPSloppy("a")
+}
+`,
+	},
+	{
+		Name: "change with the netutils name of a local variable",
+		In: `package main
+
+import "net"
+
+func f(s string) net.IP {
+	netutils := net.ParseIP(s)
+	return netutils
+}
+`,
+		Out: `package main
+
+import (
+	"net"
+
+	netutils2 "k8s.io/utils/net"
+)
+
+func f(s string) net.IP {
+	netutils := netutils2.ParseIPSloppy(s)
+	return netutils
+}
+`,
+	},
+	{
+		Name: "change in if init statements",
+		In: `package main
+
+import "net"
+
+func f(s string) net.IP {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip
+	}
+	return nil
+}
+`,
+		Out: `package main
+
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+func f(s string) net.IP {
+	if ip := netutils.ParseIPSloppy(s); ip != nil {
+		return ip
+	}
+	return nil
+}
+`,
+	},
+	{
+		Name: "change in for init statements",
+		In: `package main
+
+import "net"
+
+func f(s string) net.IP {
+	for _, n, err := net.ParseCIDR(s); err == nil && n != nil; n = nil {
+		return n.IP
+	}
+	return nil
+}
+`,
+		Out: `package main
+
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+func f(s string) net.IP {
+	for _, n, err := netutils.ParseCIDRSloppy(s); err == nil && n != nil; n = nil {
+		return n.IP
+	}
+	return nil
+}
+`,
+	},
+	{
+		Name: "change in switch init statements",
+		In: `package main
+
+import "net"
+
+func f(s string) net.IP {
+	switch ip := net.ParseIP(s); {
+	case ip == nil:
+		return nil
+	default:
+		return ip
+	}
+}
+`,
+		Out: `package main
+
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+func f(s string) net.IP {
+	switch ip := netutils.ParseIPSloppy(s); {
+	case ip == nil:
+		return nil
+	default:
+		return ip
+	}
+}
+`,
+	},
+	{
+		Name: "change in aligned composite literals",
+		In: `package main
+
+import "net"
+
+type endpoint struct {
+	Name    string
+	IP      net.IP
+	Port    int
+	Enabled bool
+}
+
+var endpoints = []endpoint{
+	{
+		Name:    "api",
+		IP:      net.ParseIP("10.0.0.1"),
+		Port:    443,
+		Enabled: true,
+	},
+	{Name: "dns", IP: net.ParseIP("10.0.0.10"), Port: 53},
+}
+
+var byName = map[string]net.IP{
+	"a":       net.ParseIP("1.1.1.1"),
+	"longest": net.ParseIP("2.2.2.2"),
+}
+`,
+		Out: `package main
+
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+type endpoint struct {
+	Name    string
+	IP      net.IP
+	Port    int
+	Enabled bool
+}
+
+var endpoints = []endpoint{
+	{
+		Name:    "api",
+		IP:      netutils.ParseIPSloppy("10.0.0.1"),
+		Port:    443,
+		Enabled: true,
+	},
+	{Name: "dns", IP: netutils.ParseIPSloppy("10.0.0.10"), Port: 53},
+}
+
+var byName = map[string]net.IP{
+	"a":       netutils.ParseIPSloppy("1.1.1.1"),
+	"longest": netutils.ParseIPSloppy("2.2.2.2"),
+}
+`,
+	},
+	{
+		Name: "change keeping nolint directives",
+		In: `package main
+
+import "net"
+
+func f(s string) net.IP {
+	ip := net.ParseIP(s)        //nolint:gocritic
+	_, n, _ := net.ParseCIDR(s) //nolint:errcheck,gocritic // parsed above
+	if n == nil {
+		return nil
+	}
+	return ip
+}
+`,
+		Out: `package main
+
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+func f(s string) net.IP {
+	ip := netutils.ParseIPSloppy(s)        //nolint:gocritic
+	_, n, _ := netutils.ParseCIDRSloppy(s) //nolint:errcheck,gocritic // parsed above
+	if n == nil {
+		return nil
+	}
+	return ip
+}
+`,
+	},
+	{
+		Name: "change calls inside conversions",
+		In: `package main
+
+import "net"
+
+func f(s string) net.IP {
+	return net.IP(net.ParseIP(s))
+}
+
+func g(v interface{}) net.IP {
+	ip, _ := v.(net.IP)
+	return net.IP(ip.To16())
+}
+`,
+		Out: `package main
+
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+func f(s string) net.IP {
+	return net.IP(netutils.ParseIPSloppy(s))
+}
+
+func g(v interface{}) net.IP {
+	ip, _ := v.(net.IP)
+	return net.IP(ip.To16())
+}
+`,
+	},
+	{
+		Name: "change the only import",
+		In: `package main
+
+import "net"
+
+var ip = net.ParseIP("1.2.3.4")
+`,
+		Out: `package main
+
+import (
+	netutils "k8s.io/utils/net"
+)
+
+var ip = netutils.ParseIPSloppy("1.2.3.4")
+`,
+	},
+	{
+		Name: "change the only import of a block",
+		In: `package main
+
+import (
+	"net"
+)
+
+var ip = net.ParseIP("1.2.3.4")
+`,
+		Out: `package main
+
+import (
+	netutils "k8s.io/utils/net"
+)
+
+var ip = netutils.ParseIPSloppy("1.2.3.4")
+`,
+	},
+	{
+		Name: "change in variadic call arguments",
+		In: `package main
+
+import (
+	"fmt"
+	"net"
+)
+
+func f(ips []net.IP, a, b string, rest ...string) []net.IP {
+	ips = append(ips, net.ParseIP(a))
+	fmt.Println(net.ParseIP(a), net.ParseIP(b))
+	args := []interface{}{net.ParseIP(b)}
+	fmt.Println(args...)
+	return append(ips, []net.IP{net.ParseIP(rest[0])}...)
+}
+`,
+		Out: `package main
+
+import (
+	"fmt"
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+func f(ips []net.IP, a, b string, rest ...string) []net.IP {
+	ips = append(ips, netutils.ParseIPSloppy(a))
+	fmt.Println(netutils.ParseIPSloppy(a), netutils.ParseIPSloppy(b))
+	args := []interface{}{netutils.ParseIPSloppy(b)}
+	fmt.Println(args...)
+	return append(ips, []net.IP{netutils.ParseIPSloppy(rest[0])}...)
+}
+`,
+	},
+	{
+		Name: "change in multi-value returns",
+		In: `package main
+
+import "net"
+
+func ip(s string) (net.IP, error) {
+	return net.ParseIP(s), nil
+}
+
+func cidr(s string) (net.IP, *net.IPNet, error) {
+	return net.ParseCIDR(s)
+}
+
+func both(a, b string) (net.IP, net.IP, bool) {
+	return net.ParseIP(a), net.ParseIP(b), true
+}
+`,
+		Out: `package main
+
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+func ip(s string) (net.IP, error) {
+	return netutils.ParseIPSloppy(s), nil
+}
+
+func cidr(s string) (net.IP, *net.IPNet, error) {
+	return netutils.ParseCIDRSloppy(s)
+}
+
+func both(a, b string) (net.IP, net.IP, bool) {
+	return netutils.ParseIPSloppy(a), netutils.ParseIPSloppy(b), true
+}
+`,
+	},
+	{
+		Name: "keep net used in a map type",
+		In: `package main
+
+import "net"
+
+var ips map[string]net.IP
+
+func f(s string) {
+	ips[s] = net.ParseIP(s)
+}
+`,
+		Out: `package main
+
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+var ips map[string]net.IP
+
+func f(s string) {
+	ips[s] = netutils.ParseIPSloppy(s)
+}
+`,
+	},
+	{
+		Name: "keep net used in a func parameter type",
+		In: `package main
+
+import "net"
+
+func f(s string, check func(net.IP) error) error {
+	return check(net.ParseIP(s))
+}
+`,
+		Out: `package main
+
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+func f(s string, check func(net.IP) error) error {
+	return check(netutils.ParseIPSloppy(s))
+}
+`,
+	},
+	{
+		Name: "keep net used in a chan type",
+		In: `package main
+
+import "net"
+
+func f(s string, nets chan *net.IPNet) {
+	_, n, _ := net.ParseCIDR(s)
+	nets <- n
+}
+`,
+		Out: `package main
 
-	"golang.org/x/tools/imports"
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
 )
 
-type testCase struct {
-	Name string
-	Fn   func(*ast.File) bool
-	In   string
-	Out  string
+func f(s string, nets chan *net.IPNet) {
+	_, n, _ := netutils.ParseCIDRSloppy(s)
+	nets <- n
 }
-
-var testCases = []testCase{
+`,
+	},
 	{
-		Name: "no change - only net",
+		Name: "change in a parenthesized var block",
 		In: `package main
 
 import "net"
 
-func f() net.Addr {
-	a := &net.IPAddr{ip1}
-	sub(&net.UDPAddr{ip2, 12345})
-	c := &net.TCPAddr{IP: ip3, Port: 54321}
-	d := &net.TCPAddr{ip4, 0}
-	p := 1234
-	e := &net.TCPAddr{ip4, p}
-	return &net.TCPAddr{ip5}, nil
+const n = iota
+
+var (
+	count         = 1
+	a             = net.ParseIP("1.2.3.4")
+	_, b, errCIDR = net.ParseCIDR("10.0.0.0/8")
+	name          string
+	c, d          = net.ParseIP("::1"), net.ParseIP("::2")
+)
+`,
+		Out: `package main
+
+import (
+	netutils "k8s.io/utils/net"
+)
+
+const n = iota
+
+var (
+	count         = 1
+	a             = netutils.ParseIPSloppy("1.2.3.4")
+	_, b, errCIDR = netutils.ParseCIDRSloppy("10.0.0.0/8")
+	name          string
+	c, d          = netutils.ParseIPSloppy("::1"), netutils.ParseIPSloppy("::2")
+)
+`,
+	},
+	{
+		Name: "keep the license and build constraints above the package clause",
+		In: `// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License").
+
+//go:build linux
+// +build linux
+
+// Package main parses addresses.
+package main
+
+import "net"
+
+var ip = net.ParseIP("1.2.3.4")
+`,
+		Out: `// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License").
+
+//go:build linux
+// +build linux
+
+// Package main parses addresses.
+package main
+
+import (
+	netutils "k8s.io/utils/net"
+)
+
+var ip = netutils.ParseIPSloppy("1.2.3.4")
+`,
+	},
+	{
+		Name: "change bare calls of a dot import",
+		In: `package main
+
+import (
+	. "net"
+)
+
+var ips = []IP{ParseIP("1.2.3.4")}
+
+func f(s string) (*IPNet, error) {
+	_, n, err := ParseCIDR(s)
+	return n, err
 }
 `,
 		Out: `package main
 
+import (
+	. "net"
+
+	netutils "k8s.io/utils/net"
+)
+
+var ips = []IP{netutils.ParseIPSloppy("1.2.3.4")}
+
+func f(s string) (*IPNet, error) {
+	_, n, err := netutils.ParseCIDRSloppy(s)
+	return n, err
+}
+`,
+	},
+	{
+		Name: "change calls with arguments over several lines",
+		In: `package main
+
 import "net"
 
-func f() net.Addr {
-	a := &net.IPAddr{ip1}
-	sub(&net.UDPAddr{ip2, 12345})
-	c := &net.TCPAddr{IP: ip3, Port: 54321}
-	d := &net.TCPAddr{ip4, 0}
-	p := 1234
-	e := &net.TCPAddr{ip4, p}
-	return &net.TCPAddr{ip5}, nil
+func buildLongCIDRString(a, b, c string) string { return a + b + c }
+
+func f(a, b, c string) {
+	_, n, err := net.ParseCIDR(
+		buildLongCIDRString(a, b, c),
+	)
+	ip := net.ParseIP(buildLongCIDRString(
+		a,
+		b,
+		c,
+	))
+	_, _, _ = n, err, ip
+}
+`,
+		Out: `package main
+
+import (
+	netutils "k8s.io/utils/net"
+)
+
+func buildLongCIDRString(a, b, c string) string { return a + b + c }
+
+func f(a, b, c string) {
+	_, n, err := netutils.ParseCIDRSloppy(
+		buildLongCIDRString(a, b, c),
+	)
+	ip := netutils.ParseIPSloppy(buildLongCIDRString(
+		a,
+		b,
+		c,
+	))
+	_, _, _ = n, err, ip
 }
 `,
 	},
 	{
-		Name: "change net.ParseIP",
+		Name: "change in type switch cases",
 		In: `package main
 
+import (
+	"fmt"
+	"net"
+)
+
+func parse(x interface{}) (interface{}, error) {
+	switch v := x.(type) {
+	case string:
+		ip := net.ParseIP(v)
+		return ip, nil
+	case fmt.Stringer:
+		_, n, err := net.ParseCIDR(v.String())
+		return n, err
+	default:
+		return nil, fmt.Errorf("unexpected %T", v)
+	}
+}
+`,
+		Out: `package main
+
+import (
+	"fmt"
+
+	netutils "k8s.io/utils/net"
+)
+
+func parse(x interface{}) (interface{}, error) {
+	switch v := x.(type) {
+	case string:
+		ip := netutils.ParseIPSloppy(v)
+		return ip, nil
+	case fmt.Stringer:
+		_, n, err := netutils.ParseCIDRSloppy(v.String())
+		return n, err
+	default:
+		return nil, fmt.Errorf("unexpected %T", v)
+	}
+}
+`,
+	},
+	{
+		Name: "keyed struct literal fields of a package level var",
+		In: `package config
+
 import "net"
 
-func f() net.Addr {
-	a := &net.IPAddr{ip1}
-	c := net.ParseIP("ads")
-	return &net.TCPAddr{ip5}, nil
+type Config struct {
+	Listen  net.IP
+	Service *net.IPNet
+}
+
+var Defaults = Config{
+	Listen:  net.ParseIP("0.0.0.0"),
+	Service: ipNet(net.ParseCIDR("10.96.0.0/12")),
+}
+
+func ipNet(_ net.IP, n *net.IPNet, err error) *net.IPNet {
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+`,
+		Out: `package config
+
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+type Config struct {
+	Listen  net.IP
+	Service *net.IPNet
+}
+
+var Defaults = Config{
+	Listen:  netutils.ParseIPSloppy("0.0.0.0"),
+	Service: ipNet(netutils.ParseCIDRSloppy("10.96.0.0/12")),
+}
+
+func ipNet(_ net.IP, n *net.IPNet, err error) *net.IPNet {
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+`,
+	},
+	{
+		Name: "calls in go and deferred closures",
+		In: `package main
+
+import (
+	"log"
+	"net"
+)
+
+func serve(addrs []string) {
+	for _, s := range addrs {
+		s := s
+		go func() {
+			_ = net.ParseIP(s)
+		}()
+	}
+	defer func() {
+		if _, _, err := net.ParseCIDR(addrs[0]); err != nil {
+			log.Print(err)
+		}
+	}()
 }
 `,
 		Out: `package main
 
+import (
+	"log"
+
+	netutils "k8s.io/utils/net"
+)
+
+func serve(addrs []string) {
+	for _, s := range addrs {
+		s := s
+		go func() {
+			_ = netutils.ParseIPSloppy(s)
+		}()
+	}
+	defer func() {
+		if _, _, err := netutils.ParseCIDRSloppy(addrs[0]); err != nil {
+			log.Print(err)
+		}
+	}()
+}
+`,
+	},
+	{
+		Name: "external test package importing the package under test",
+		In: `package foo_test
+
 import (
 	"net"
+	"testing"
+
+	"example.com/foo"
+)
+
+func TestParse(t *testing.T) {
+	ip := net.ParseIP("10.0.0.1")
+	if got := foo.Parse("10.0.0.1"); !got.Equal(ip) {
+		t.Errorf("got %v, want %v", got, ip)
+	}
+}
+`,
+		Out: `package foo_test
+
+import (
+	"testing"
 
+	"example.com/foo"
 	netutils "k8s.io/utils/net"
 )
 
-func f() net.Addr {
-	a := &net.IPAddr{ip1}
-	c := netutils.ParseIPSloppy("ads")
-	return &net.TCPAddr{ip5}, nil
+func TestParse(t *testing.T) {
+	ip := netutils.ParseIPSloppy("10.0.0.1")
+	if got := foo.Parse("10.0.0.1"); !got.Equal(ip) {
+		t.Errorf("got %v, want %v", got, ip)
+	}
 }
 `,
 	},
 	{
-		Name: "change net.ParseIP and ParseCIDR",
+		Name: "calls in the composite literal of a range clause",
 		In: `package main
 
-import "net"
+import (
+	"fmt"
+	"net"
+)
 
-func f() net.Addr {
-	a := &net.IPAddr{ip1}
-	c := net.ParseIP("ads")
-	d, _, err := net.ParseCIDR("ads")
-	return &net.TCPAddr{ip5}, nil
+func f(a, b string) {
+	for i, ip := range []net.IP{net.ParseIP(a), net.ParseIP(b)} {
+		fmt.Println(i, ip)
+	}
+	for range [...]net.IP{net.ParseIP(a)} {
+	}
 }
 `,
 		Out: `package main
 
 import (
+	"fmt"
 	"net"
 
 	netutils "k8s.io/utils/net"
 )
 
-func f() net.Addr {
-	a := &net.IPAddr{ip1}
-	c := netutils.ParseIPSloppy("ads")
-	d, _, err := netutils.ParseCIDRSloppy("ads")
-	return &net.TCPAddr{ip5}, nil
+func f(a, b string) {
+	for i, ip := range []net.IP{netutils.ParseIPSloppy(a), netutils.ParseIPSloppy(b)} {
+		fmt.Println(i, ip)
+	}
+	for range [...]net.IP{netutils.ParseIPSloppy(a)} {
+	}
 }
 `,
 	},
 	{
-		Name: "change net.ParseIP and ParseCIDR and remove net",
+		Name: "unicode alias of net",
+		In: `package main
+
+import réseau "net"
+
+func f(s string) réseau.IP {
+	return réseau.ParseIP(s)
+}
+`,
+		Out: `package main
+
+import (
+	réseau "net"
+
+	netutils "k8s.io/utils/net"
+)
+
+func f(s string) réseau.IP {
+	return netutils.ParseIPSloppy(s)
+}
+`,
+	},
+	{
+		Name: "unicode aliases of net and k8s.io/utils/net and remove net",
+		In: `package main
+
+import (
+	réseau "net"
+
+	ネット "k8s.io/utils/net"
+)
+
+func f(s string) bool {
+	return ネット.IsIPv4(réseau.ParseIP(s))
+}
+`,
+		Out: `package main
+
+import (
+	netutils "k8s.io/utils/net"
+)
+
+func f(s string) bool {
+	return netutils.IsIPv4(netutils.ParseIPSloppy(s))
+}
+`,
+	},
+	{
+		Name: "calls in immediately invoked function literals",
 		In: `package main
 
 import "net"
 
-func f() {
-	c := net.ParseIP("ads")
-	d, _, err := net.ParseCIDR("ads")
+func f(s string) (net.IP, *net.IPNet) {
+	ip := func() net.IP { return net.ParseIP(s) }()
+	ipNet := func() *net.IPNet {
+		_, n, err := net.ParseCIDR(s + "/24")
+		if err != nil {
+			return nil
+		}
+		return n
+	}()
+	return ip, ipNet
 }
 `,
 		Out: `package main
 
 import (
+	"net"
+
 	netutils "k8s.io/utils/net"
 )
 
-func f() {
-	c := netutils.ParseIPSloppy("ads")
-	d, _, err := netutils.ParseCIDRSloppy("ads")
+func f(s string) (net.IP, *net.IPNet) {
+	ip := func() net.IP { return netutils.ParseIPSloppy(s) }()
+	ipNet := func() *net.IPNet {
+		_, n, err := netutils.ParseCIDRSloppy(s + "/24")
+		if err != nil {
+			return nil
+		}
+		return n
+	}()
+	return ip, ipNet
 }
 `,
 	},
 	{
-		Name: "existing netutils and change net.ParseIP and ParseCIDR and remove net",
+		Name: "third party imports named unlike their path",
 		In: `package main
 
 import (
 	"net"
 
-	utilnet "k8s.io/utils/net"
+	"github.com/mattn/go-isatty"
+	"gopkg.in/yaml.v3"
 )
 
-func f() {
-	c := net.ParseIP("ads")
-	d, _, err := net.ParseCIDR("ads")
-	utilnet.IsIPv6(d)
+func f(s string) (net.IP, bool) {
+	var v yaml.Node
+	_ = v
+	return net.ParseIP(s), isatty.IsTerminal(0)
 }
 `,
 		Out: `package main
 
 import (
+	"net"
+
+	"github.com/mattn/go-isatty"
+	"gopkg.in/yaml.v3"
 	netutils "k8s.io/utils/net"
 )
 
-func f() {
-	c := netutils.ParseIPSloppy("ads")
-	d, _, err := netutils.ParseCIDRSloppy("ads")
-	netutils.IsIPv6(d)
+func f(s string) (net.IP, bool) {
+	var v yaml.Node
+	_ = v
+	return netutils.ParseIPSloppy(s), isatty.IsTerminal(0)
 }
 `,
 	},
@@ -164,7 +1462,7 @@ func f() {
 
 func fnop(*ast.File) bool { return false }
 
-func parseFixPrint(t *testing.T, desc, in string, mustBeGofmt bool) (out string, fixed, ok bool) {
+func parseFixPrint(t *testing.T, fn func(*ast.File) bool, fixImports importFixer, desc, in string, mustBeGofmt bool) (out string, fixed, ok bool) {
 	file, err := parser.ParseFile(fset, desc, in, parserMode)
 	if err != nil {
 		t.Errorf("parsing: %v", err)
@@ -183,7 +1481,7 @@ func parseFixPrint(t *testing.T, desc, in string, mustBeGofmt bool) (out string,
 		return
 	}
 
-	fixed = sloppyParsers(file)
+	fixed = fn(file)
 
 	outb, err = gofmtFile(file)
 	if err != nil {
@@ -191,7 +1489,7 @@ func parseFixPrint(t *testing.T, desc, in string, mustBeGofmt bool) (out string,
 		return
 	}
 
-	outc, err := imports.Process("", outb, nil)
+	outc, err := fixImports(desc, outb)
 	if err != nil {
 		t.Errorf("printing: %v", err)
 		return
@@ -201,18 +1499,32 @@ func parseFixPrint(t *testing.T, desc, in string, mustBeGofmt bool) (out string,
 }
 
 func TestRewrite(t *testing.T) {
+	testRewrite(t, formatImports)
+}
+
+// TestRewriteGOPATH checks that the rewrite is the same when the
+// imports are fixed without goimports.
+func TestRewriteGOPATH(t *testing.T) {
+	testRewrite(t, astutilImports)
+}
+
+func testRewrite(t *testing.T, fixImports importFixer) {
 	for _, tt := range testCases {
 		tt := tt
 		t.Run(tt.Name, func(t *testing.T) {
 			t.Parallel()
+			fn := tt.Fn
+			if fn == nil {
+				fn = func(f *ast.File) bool { return sloppyParsers(f, nil) }
+			}
 			// Apply fix: should get tt.Out.
-			out, fixed, ok := parseFixPrint(t, tt.Name, tt.In, true)
+			out, fixed, ok := parseFixPrint(t, fn, fixImports, tt.Name, tt.In, true)
 			if !ok {
 				return
 			}
 
 			// reformat to get printing right
-			out, _, ok = parseFixPrint(t, tt.Name, out, false)
+			out, _, ok = parseFixPrint(t, fn, fixImports, tt.Name, out, false)
 			if !ok {
 				return
 			}
@@ -232,7 +1544,7 @@ func TestRewrite(t *testing.T) {
 			}
 
 			// Should not change if run again.
-			out2, fixed2, ok := parseFixPrint(t, tt.Name+" output", out, true)
+			out2, fixed2, ok := parseFixPrint(t, fn, fixImports, tt.Name+" output", out, true)
 			if !ok {
 				return
 			}
@@ -259,3 +1571,22 @@ func tdiff(t *testing.T, a, b string) {
 	}
 	t.Error(string(data))
 }
+
+func TestTrimPath(t *testing.T) {
+	tests := []struct {
+		path, prefix, want string
+	}{
+		{"/workspace/src/pkg/a.go", "", "/workspace/src/pkg/a.go"},
+		{"/workspace/src/pkg/a.go", "/workspace/src", "pkg/a.go"},
+		{"/workspace/src/pkg/a.go", "/workspace/src/", "pkg/a.go"},
+		{"/workspace/srcs/pkg/a.go", "/workspace/src", "/workspace/srcs/pkg/a.go"},
+		{"/other/pkg/a.go", "/workspace/src", "/other/pkg/a.go"},
+		{"src/pkg/a.go", "src", "pkg/a.go"},
+		{"/", "/", "."},
+	}
+	for _, tt := range tests {
+		if got := trimPath(tt.path, tt.prefix); got != tt.want {
+			t.Errorf("trimPath(%q, %q) = %q, want %q", tt.path, tt.prefix, got, tt.want)
+		}
+	}
+}
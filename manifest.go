@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// manifestEntry records a rewritten file, for -manifest: the hashes
+// tell whether it was modified since, by another tool or by hand.
+type manifestEntry struct {
+	Path   string `json:"path"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// manifest holds the files written, by absolute path, if not nil.
+var manifest map[string]manifestEntry
+
+// recordWrite records in manifest, if not nil, that the file path is
+// rewritten from before to after.
+func recordWrite(path string, before, after []byte) {
+	if manifest == nil {
+		return
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	manifest[path] = manifestEntry{Path: path, Before: contentHash(before), After: contentHash(after)}
+}
+
+// contentHash returns the hash of the content of a file, like
+// "sha256:e3b0c442…".
+func contentHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// writeManifest writes manifest to the file name, as a JSON list
+// sorted by path, replacing it atomically.
+func writeManifest(name string) error {
+	entries := make([]manifestEntry, 0, len(manifest))
+	for _, e := range manifest {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	data, err := json.MarshalIndent(entries, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(name, append(data, '\n'), 0644)
+}
+
+// writeFileAtomic writes data to the file name, replacing it
+// atomically: a temporary file with the permissions perm is written in
+// the same directory and renamed. The temporary file is removed if any
+// step fails.
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), perm)
+	}
+	if err == nil {
+		err = renameFile(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// renameFile is os.Rename, replaced by the tests.
+var renameFile = os.Rename
@@ -1,43 +1,398 @@
 package main
 
 import (
+	"fmt"
 	"go/ast"
+	"go/token"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
 )
 
-func sloppyParsers(f *ast.File) bool {
-	if ok, _ := getImport(f, "net"); !ok {
-		return false
+// ignoreDirective marks a call that must not be rewritten. It goes
+// at the end of the line of the call or on the line above it, and
+// may carry a reason: //sloppy:ignore(benchmark baseline).
+const ignoreDirective = "//sloppy:ignore"
+
+// directive is a //sloppy:ignore comment found in a file.
+type directive struct {
+	comment *ast.Comment
+	reason  string
+	used    bool
+}
+
+// parseIgnore reports whether the comment text is an ignore directive
+// and returns its reason, if any.
+func parseIgnore(text string) (reason string, ok bool) {
+	rest := strings.TrimPrefix(text, ignoreDirective)
+	if rest == text {
+		return "", false
 	}
+	rest = strings.TrimSpace(rest)
+	switch {
+	case rest == "":
+		return "", true
+	case strings.HasPrefix(rest, "(") && strings.HasSuffix(rest, ")"):
+		return strings.TrimSpace(rest[1 : len(rest)-1]), true
+	}
+	return "", false
+}
 
-	fixed := false
-	walk(f, func(n interface{}) {
-		ce, ok := n.(*ast.CallExpr)
+// position returns the position of p as found on disk, ignoring
+// //line directives: generated files use them to point at their
+// source, but the rewrite applies to the generated file.
+func position(p token.Pos) token.Position {
+	return fset.PositionFor(p, false)
+}
+
+// ignoreDirectives returns the ignore directives in f, keyed by line.
+func ignoreDirectives(f *ast.File) map[int]*directive {
+	directives := make(map[int]*directive)
+	for _, cg := range f.Comments {
+		for _, c := range cg.List {
+			if reason, ok := parseIgnore(c.Text); ok {
+				directives[position(c.Pos()).Line] = &directive{comment: c, reason: reason}
+			}
+		}
+	}
+	return directives
+}
+
+// misplacedDirectives returns a warning for each of the directives of
+// f that did not apply to any call.
+func misplacedDirectives(f *ast.File, directives map[int]*directive) []string {
+	var warnings []string
+	for _, cg := range f.Comments {
+		for _, c := range cg.List {
+			pos := position(c.Pos())
+			if d := directives[pos.Line]; d != nil && d.comment == c && !d.used {
+				warnings = append(warnings,
+					fmt.Sprintf("%s: misplaced %s directive, no matching call", pos, ignoreDirective))
+			}
+		}
+	}
+	return warnings
+}
+
+// sloppyParsers rewrites the references to net.ParseIP and
+// net.ParseCIDR in f, calls or values, to their k8s.io/utils/net
+// sloppy counterparts. If res is not nil, the rewritten references
+// are recorded in it.
+func sloppyParsers(f *ast.File, res *Result) bool {
+	return applyRules(f, sloppyRules, res)
+}
+
+// usesName reports whether f refers to the top-level name as the
+// base of a selector, like a package name.
+func usesName(f *ast.File, name string) bool {
+	used := false
+	ast.Inspect(f, func(n ast.Node) bool {
+		if se, ok := n.(*ast.SelectorExpr); ok && isTopName(se.X, name) {
+			used = true
+		}
+		return !used
+	})
+	return used
+}
+
+// rulesByName maps the local names of the packages of rules imported
+// by f to the rules of their functions, for ruleSelector. A package
+// may be imported several times, under different names, in the middle
+// of a merge: every name is included. The dot imported packages are
+// keyed by ".", see dotSelectors.
+func rulesByName(f *ast.File, rules []Rule) map[string]map[string]*Rule {
+	byName := make(map[string]map[string]*Rule)
+	for i := range rules {
+		r := &rules[i]
+		for _, s := range importSpecs(f, r.Path) {
+			name := importName(s)
+			if name == "_" {
+				continue
+			}
+			if byName[name] == nil {
+				byName[name] = make(map[string]*Rule)
+			}
+			byName[name][r.Name] = r
+		}
+	}
+	return byName
+}
+
+// ruleSelector returns the rule matching n, if n is a reference to
+// the function of a rule. rules maps the local names of the imported
+// packages to the rules of their functions. The base of the selector
+// must be a top-level identifier: fields, methods and shadowing
+// variables do not match.
+func ruleSelector(n ast.Node, rules map[string]map[string]*Rule) (*ast.SelectorExpr, *Rule) {
+	se, ok := n.(*ast.SelectorExpr)
+	if !ok || se.Sel == nil {
+		return nil, nil
+	}
+	id, ok := se.X.(*ast.Ident)
+	if !ok || id.Obj != nil {
+		return nil, nil
+	}
+	r := rules[id.Name][se.Sel.Name]
+	if r == nil || r.Match != nil && !r.Match(se) {
+		return nil, nil
+	}
+	return se, r
+}
+
+// dotSelectors replaces the references to the functions of rules, dot
+// imported by f, which are bare identifiers like ParseIP, with
+// selectors of the "." package, like ".ParseIP", for ruleSelector.
+// It returns the identifiers replaced, by selector, for undotSelectors.
+// The identifiers declared in f, or that are not references, like
+// field names, are left alone.
+func dotSelectors(f *ast.File, rules map[string]*Rule) map[*ast.SelectorExpr]*ast.Ident {
+	if len(rules) == 0 {
+		return nil
+	}
+	dotted := make(map[*ast.SelectorExpr]*ast.Ident)
+	astutil.Apply(f, func(c *astutil.Cursor) bool {
+		id, ok := c.Node().(*ast.Ident)
+		if !ok || id.Obj != nil || rules[id.Name] == nil {
+			return true
+		}
+		switch c.Parent().(type) {
+		case *ast.SelectorExpr:
+			if c.Name() == "Sel" {
+				return true
+			}
+		case *ast.KeyValueExpr:
+			if c.Name() == "Key" {
+				return true
+			}
+		case *ast.File, *ast.FuncDecl, *ast.Field, *ast.BranchStmt, *ast.LabeledStmt:
+			return true
+		}
+		se := &ast.SelectorExpr{
+			X:   &ast.Ident{NamePos: id.Pos(), Name: "."},
+			Sel: &ast.Ident{NamePos: id.Pos(), Name: id.Name},
+		}
+		dotted[se] = id
+		c.Replace(se)
+		return true
+	}, nil)
+	return dotted
+}
+
+// undotSelectors restores the identifiers of the selectors of
+// dotSelectors that were not rewritten.
+func undotSelectors(f *ast.File, dotted map[*ast.SelectorExpr]*ast.Ident) {
+	if len(dotted) == 0 {
+		return
+	}
+	astutil.Apply(f, func(c *astutil.Cursor) bool {
+		if se, ok := c.Node().(*ast.SelectorExpr); ok && dotted[se] != nil {
+			c.Replace(dotted[se])
+		}
+		return true
+	}, nil)
+}
+
+// wrapperFuncs returns the top-level functions of f whose body only
+// returns the result of a call to the function of a rule, keyed by
+// the selector of the call, with the calls to them in f.
+func wrapperFuncs(f *ast.File, rules map[string]map[string]*Rule) map[*ast.SelectorExpr]Wrapper {
+	wrappers := make(map[*ast.SelectorExpr]Wrapper)
+	byObj := make(map[*ast.Object]*ast.SelectorExpr)
+	for _, d := range f.Decls {
+		fd, ok := d.(*ast.FuncDecl)
+		if !ok || fd.Recv != nil || fd.Body == nil || len(fd.Body.List) != 1 {
+			continue
+		}
+		ret, ok := fd.Body.List[0].(*ast.ReturnStmt)
+		if !ok || len(ret.Results) != 1 {
+			continue
+		}
+		call, ok := ret.Results[0].(*ast.CallExpr)
 		if !ok {
-			return
+			continue
+		}
+		se, r := ruleSelector(call.Fun, rules)
+		if r == nil {
+			continue
+		}
+		wrappers[se] = Wrapper{Func: fd.Name.Name, Name: r.Name, Pos: position(fd.Name.Pos())}
+		byObj[fd.Name.Obj] = se
+	}
+	if len(wrappers) == 0 {
+		return nil
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		if id, ok := call.Fun.(*ast.Ident); ok && id.Obj != nil {
+			if se, ok := byObj[id.Obj]; ok {
+				w := wrappers[se]
+				w.Callers = append(w.Callers, position(call.Pos()))
+				wrappers[se] = w
+			}
+		}
+		return true
+	})
+	return wrappers
+}
+
+// applyRules rewrites the references to the functions of rules in f,
+// calls or values. If res is not nil, the rewritten references are
+// recorded in it.
+func applyRules(f *ast.File, rules []Rule, res *Result) bool {
+	byName := rulesByName(f, rules)
+	if len(byName) == 0 {
+		return false
+	}
+	defer undotSelectors(f, dotSelectors(f, byName["."]))
+
+	directives := ignoreDirectives(f)
+	// A directive on the line of a reference applies to it,
+	// otherwise it applies to the reference on the next line.
+	refLines := make(map[int]bool)
+	ast.Inspect(f, func(n ast.Node) bool {
+		if se, r := ruleSelector(n, byName); r != nil {
+			refLines[position(se.Pos()).Line] = true
+		}
+		return true
+	})
+	// ignored reports whether a directive applies to se
+	// and records it as skipped.
+	ignored := func(se *ast.SelectorExpr) bool {
+		line := position(se.Pos()).Line
+		d, ok := directives[line]
+		if !ok && !refLines[line-1] {
+			d, ok = directives[line-1]
 		}
-		se, ok := ce.Fun.(*ast.SelectorExpr)
 		if !ok {
-			return
+			return false
+		}
+		d.used = true
+		if res != nil {
+			res.Ignored = append(res.Ignored, Ignored{
+				Name:   se.Sel.Name,
+				Pos:    position(se.Pos()),
+				Reason: d.reason,
+			})
 		}
-		if !isTopName(se.X, "net") || se.Sel == nil {
-			return
+		return true
+	}
+
+	// aliases holds the names the targets are imported as, see freeName.
+	aliases := make(map[string]string)
+	aliasOf := func(r *Rule) string {
+		alias, ok := aliases[r.TargetPath]
+		if !ok {
+			alias = freeName(f, r.TargetAlias, r.TargetPath)
+			if !renameExistingAlias {
+				if name := existingAlias(f, r.TargetPath); name != "" {
+					alias = name
+				}
+			}
+			aliases[r.TargetPath] = alias
 		}
+		return alias
+	}
+
+	wrappers := wrapperFuncs(f, byName)
 
-		switch ss := se.Sel.String(); ss {
-		case "ParseIP":
-			id, _ := se.X.(*ast.Ident)
-			id.Name = "netutils"
-			se.Sel.Name = "ParseIPSloppy"
-			fixed = true
-		case "ParseCIDR":
-			se.X.(*ast.Ident).Name = "netutils"
-			se.Sel.Name = "ParseCIDRSloppy"
-			fixed = true
+	fixed := false
+	// targets holds the rules whose target import must be added,
+	// and sources the import paths of the rewritten references.
+	var targets []*Rule
+	var sources []string
+	added := make(map[string]bool)
+	rewritten := make(map[string]bool)
+	// The external tests of a package import it, like any other.
+	externalTest := strings.HasSuffix(f.Name.Name, "_test")
+	selfWarned := make(map[string]bool)
+	astutil.Apply(f, nil, func(c *astutil.Cursor) bool {
+		se, r := ruleSelector(c.Node(), byName)
+		if r == nil || ignored(se) {
+			return true
+		}
+		switch {
+		case r.selfImport && !externalTest:
+			if res != nil && !selfWarned[r.TargetPath] {
+				selfWarned[r.TargetPath] = true
+				res.Warnings = append(res.Warnings,
+					fmt.Sprintf("%s: %s not rewritten, the file is in %s: importing it would be a cycle", position(se.Pos()), se.Sel.Name, r.TargetPath))
+			}
+			return true
+		case r.samePackage && !externalTest:
+			id := &ast.Ident{NamePos: se.Pos(), Name: r.TargetName}
+			c.Replace(id)
+			if res != nil {
+				if res.bareEnds == nil {
+					res.bareEnds = make(map[*ast.Ident]token.Pos)
+				}
+				res.bareEnds[id] = se.End()
+			}
+		default:
+			// Keep the positions, so comments stay in place.
+			c.Replace(&ast.SelectorExpr{
+				X:   &ast.Ident{NamePos: se.X.Pos(), Name: aliasOf(r)},
+				Sel: &ast.Ident{NamePos: se.Sel.Pos(), Name: r.TargetName},
+			})
+			if !added[r.TargetPath] {
+				added[r.TargetPath] = true
+				targets = append(targets, r)
+			}
 		}
+		if !rewritten[r.Path] {
+			rewritten[r.Path] = true
+			sources = append(sources, r.Path)
+		}
+		fixed = true
+		if res != nil {
+			res.addCall(se.Sel.Name, position(se.Pos()))
+			if w, ok := wrappers[se]; ok {
+				res.Wrappers = append(res.Wrappers, w)
+			}
+		}
+		return true
 	})
-	if fixed {
-		addImport(f, "netutils", "k8s.io/utils/net")
-		rewriteImportName(f, "k8s.io/utils/net", "netutils", "k8s.io/utils/net")
+	if res != nil {
+		res.Warnings = append(res.Warnings, misplacedDirectives(f, directives)...)
+	}
+	for _, r := range targets {
+		if importSpec(f, r.TargetPath) == nil && res != nil {
+			res.ImportsAdded = append(res.ImportsAdded, r.TargetPath)
+			if res.importNames == nil {
+				res.importNames = make(map[string]string)
+			}
+			res.importNames[r.TargetPath] = aliasOf(r)
+		}
+		addImport(f, aliasOf(r), r.TargetPath)
+		rewriteImportName(f, r.TargetPath, aliasOf(r), r.TargetPath)
+	}
+	if keepImports {
+		return fixed
+	}
+	// Delete the imports no longer used, after adding the new ones
+	// so the import declarations keep their parentheses.
+	for _, path := range sources {
+		specs := importSpecs(f, path)
+		if len(specs) > 1 && res != nil {
+			res.Warnings = append(res.Warnings,
+				fmt.Sprintf("%s: %q is imported %d times, the imports need manual cleanup", position(specs[0].Pos()), path, len(specs)))
+		}
+		for _, s := range specs {
+			name := importName(s)
+			if name == "_" || name == "." || usesName(f, name) {
+				continue
+			}
+			var specName string
+			if s.Name != nil {
+				specName = s.Name.Name
+			}
+			astutil.DeleteNamedImport(fset, f, specName, path)
+		}
+		if importSpec(f, path) == nil && res != nil {
+			res.ImportsRemoved = append(res.ImportsRemoved, path)
+		}
 	}
 	return fixed
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// noFormat makes Rewrite edit the rewritten references and the imports
+// in place, instead of formatting the whole file, for -no-format: the
+// other lines stay byte for byte as they are, gofmt clean or not.
+var noFormat = false
+
+// textEdit replaces src[start:end] with text.
+type textEdit struct {
+	start, end int
+	text       string
+}
+
+// rewriteInPlace is rewriteParsed for noFormat. file is parsed from
+// src, orig with its byte order mark, if any, which is kept.
+//
+// The identifiers of the rewritten references are replaced in src,
+// one by one, so the comments and spacing around them are kept. The
+// import declarations, that rules add and remove, are replaced as a
+// whole by the ones rewriteParsed would produce.
+func rewriteInPlace(orig, src []byte, file *ast.File, filename string, rules []Rule) ([]byte, Result, error) {
+	res := Result{Filename: filename}
+
+	start, end, ok := importSection(fset, file)
+	if !ok || !applyRules(file, rules, &res) {
+		return orig, res, nil
+	}
+
+	// The identifiers applyRules renames, or replaces, keep their
+	// positions: the ones that no longer match the source are edited.
+	var edits []textEdit
+	ast.Inspect(file, func(n ast.Node) bool {
+		name := ""
+		switch n := n.(type) {
+		case *ast.Ident:
+			name = n.Name
+		case *ast.SelectorExpr:
+			// The selectors replacing the bare identifiers of dot
+			// imports have both their names at the same position.
+			x, ok := n.X.(*ast.Ident)
+			if !ok || x.Pos() != n.Sel.Pos() {
+				return true
+			}
+			name = x.Name + "." + n.Sel.Name
+		default:
+			return true
+		}
+		if !n.Pos().IsValid() {
+			return false
+		}
+		off := fset.Position(n.Pos()).Offset
+		if off >= start && off < end {
+			return false
+		}
+		if n, ok := n.(*ast.Ident); ok && res.bareEnds[n].IsValid() {
+			edits = append(edits, textEdit{off, fset.Position(res.bareEnds[n]).Offset, name})
+			return false
+		}
+		if n := identLen(src[off:]); string(src[off:off+n]) != name {
+			edits = append(edits, textEdit{off, off + n, name})
+		}
+		return false
+	})
+
+	fmtSrc, err := gofmtFile(file)
+	if err != nil {
+		return nil, res, fileError(filename, err)
+	}
+	newSrc, err := fixImports(filename, fmtSrc)
+	if err != nil {
+		return nil, res, fileError(filename, err)
+	}
+	newFset := token.NewFileSet()
+	newFile, err := parser.ParseFile(newFset, filename, newSrc, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return nil, res, err
+	}
+	if newStart, newEnd, ok := importSection(newFset, newFile); ok {
+		e := trimEdit(src, textEdit{start, end, string(newSrc[newStart:newEnd])})
+		if string(src[e.start:e.end]) != e.text {
+			edits = append(edits, e)
+		}
+	}
+
+	out := applyTextEdits(src, edits)
+	if bytes.Equal(out, src) {
+		res.Calls, res.Positions = nil, nil
+		return orig, res, nil
+	}
+	res.edits = edits
+	if bytes.HasPrefix(orig, utf8BOM) {
+		out = append(append([]byte(nil), utf8BOM...), out...)
+	}
+	return out, res, nil
+}
+
+// importSection returns the offsets of the import declarations of f,
+// from the first to the end of the last, parsed with fset.
+func importSection(fset *token.FileSet, f *ast.File) (start, end int, ok bool) {
+	for _, d := range f.Decls {
+		gd, isGen := d.(*ast.GenDecl)
+		if !isGen || gd.Tok != token.IMPORT {
+			continue
+		}
+		if !ok {
+			start, ok = fset.Position(gd.Pos()).Offset, true
+		}
+		end = fset.Position(gd.End()).Offset
+	}
+	return start, end, ok
+}
+
+// identLen returns the length of the identifier at the start of src.
+func identLen(src []byte) int {
+	n := 0
+	for n < len(src) {
+		r, size := utf8.DecodeRune(src[n:])
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		n += size
+	}
+	return n
+}
+
+// trimEdit returns e without the lines at its start and end that it
+// leaves unchanged in src, so it only covers the changed lines.
+func trimEdit(src []byte, e textEdit) textEdit {
+	old := string(src[e.start:e.end])
+	for {
+		i := strings.IndexByte(old, '\n')
+		if i < 0 || !strings.HasPrefix(e.text, old[:i+1]) {
+			break
+		}
+		old, e.text = old[i+1:], e.text[i+1:]
+		e.start += i + 1
+	}
+	for {
+		i := strings.LastIndexByte(old, '\n')
+		if i < 0 || !strings.HasSuffix(e.text, old[i:]) {
+			break
+		}
+		n := len(old) - i
+		old, e.text = old[:i], e.text[:len(e.text)-n]
+		e.end -= n
+	}
+	return e
+}
+
+// applyTextEdits returns src with the edits, that must not overlap,
+// applied. The edits are sorted in place.
+func applyTextEdits(src []byte, edits []textEdit) []byte {
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	var buf bytes.Buffer
+	last := 0
+	for _, e := range edits {
+		buf.Write(src[last:e.start])
+		buf.WriteString(e.text)
+		last = e.end
+	}
+	buf.Write(src[last:])
+	return buf.Bytes()
+}
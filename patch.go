@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+)
+
+// patchDiff returns the diff between src and out as a patch of the
+// file name, with the a/ and b/ prefixed headers git apply expects.
+// name must be relative to the directory the patch is applied in.
+func patchDiff(name string, src, out []byte) ([]byte, error) {
+	data, err := differ.Diff(name, src, out)
+	if err != nil {
+		return nil, err
+	}
+	// Replace the --- and +++ headers naming the temporary files.
+	for i := 0; i < 2; i++ {
+		j := bytes.IndexByte(data, '\n')
+		if j < 0 {
+			return nil, fmt.Errorf("malformed diff of %s", name)
+		}
+		data = data[j+1:]
+	}
+	name = filepath.ToSlash(name)
+	header := fmt.Sprintf("diff --git a/%s b/%s\n--- a/%s\n+++ b/%s\n", name, name, name, name)
+	return append([]byte(header), data...), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// progress draws a "processed/total files, directory" line to w,
+// redrawn in place as the files are processed.
+type progress struct {
+	w           io.Writer
+	done, total int
+	drawn       bool
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// countGoFiles returns the number of Go files in paths, walking the
+// directories, like WalkAndFix finds them with cfg.
+func countGoFiles(paths []string, cfg Config) int {
+	seen := make(map[string]bool)
+	ignore := cfg.ignored()
+	walkFiles(paths, cfg, func(path string) bool {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return true
+		}
+		if cfg.FollowSymlinks {
+			if real, err := filepath.EvalSymlinks(abs); err == nil {
+				abs = real
+			}
+		}
+		if !ignore[abs] {
+			seen[abs] = true
+		}
+		return true
+	})
+	return len(seen)
+}
+
+// wrap returns a WalkAndFix callback calling fn, that clears the
+// progress line before fn reports anything and redraws it after.
+func (p *progress) wrap(fn func(path string, res Result, out []byte) error) func(path string, res Result, out []byte) error {
+	return func(path string, res Result, out []byte) error {
+		p.clear()
+		err := fn(path, res, out)
+		p.done++
+		fmt.Fprintf(p.w, "%d/%d files, %s", p.done, p.total, filepath.Dir(path))
+		p.drawn = true
+		return err
+	}
+}
+
+// clear erases the progress line, if drawn.
+func (p *progress) clear() {
+	if p.drawn {
+		fmt.Fprint(p.w, "\r\x1b[K")
+		p.drawn = false
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+	var buf bytes.Buffer
+	p := &progress{w: &buf, total: 2}
+	fn := p.wrap(func(path string, res Result, out []byte) error {
+		buf.WriteString("report " + filepath.Base(path) + "\n")
+		return nil
+	})
+	fn(filepath.Join("a", "x.go"), Result{}, nil)
+	fn(filepath.Join("b", "y.go"), Result{}, nil)
+	p.clear()
+
+	want := "report x.go\n" +
+		"1/2 files, a\r\x1b[K" +
+		"report y.go\n" +
+		"2/2 files, b\r\x1b[K"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestProgressNotTerminal(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.go": dirtySrc})
+	_, stderr, _ := runMain(t, "", "-progress", "-l", dir)
+	if strings.Contains(stderr, "files,") {
+		t.Errorf("progress shown while stderr is not a terminal:\n%s", stderr)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// promLabels is a flag.Value collecting the labels of the -prom
+// metrics, given as key=value, one per flag.
+type promLabels map[string]string
+
+// labelName matches the Prometheus label names.
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func (l promLabels) String() string {
+	var pairs []string
+	for _, k := range sortedLabels(l) {
+		pairs = append(pairs, k+"="+l[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (l promLabels) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i < 0 {
+		return fmt.Errorf("%q is not key=value", s)
+	}
+	if !labelName.MatchString(s[:i]) || strings.HasPrefix(s[:i], "__") {
+		return fmt.Errorf("%q is not a valid label name", s[:i])
+	}
+	l[s[:i]] = s[i+1:]
+	return nil
+}
+
+// labelEscaper escapes the label values.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// sortedLabels returns the label names of l, sorted.
+func sortedLabels(l promLabels) []string {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// promMetrics returns the Prometheus text exposition of the calls left
+// to rewrite, counted by function name in calls, for -prom: a gauge
+// for each function name of rules, like
+//
+//	# HELP sloppy_net_parseip_remaining The ParseIP calls left to rewrite.
+//	# TYPE sloppy_net_parseip_remaining gauge
+//	sloppy_net_parseip_remaining{repo="x"} 341
+func promMetrics(rules []Rule, calls map[string]int, labels promLabels) []byte {
+	names := make(map[string]bool)
+	for _, r := range rules {
+		names[r.Name] = true
+	}
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var set string
+	if len(labels) > 0 {
+		var pairs []string
+		for _, k := range sortedLabels(labels) {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, k, labelEscaper.Replace(labels[k])))
+		}
+		set = "{" + strings.Join(pairs, ",") + "}"
+	}
+	var buf bytes.Buffer
+	for _, name := range sorted {
+		metric := "sloppy_net_" + strings.ToLower(name) + "_remaining"
+		fmt.Fprintf(&buf, "# HELP %s The %s calls left to rewrite.\n", metric, name)
+		fmt.Fprintf(&buf, "# TYPE %s gauge\n", metric)
+		fmt.Fprintf(&buf, "%s%s %d\n", metric, set, calls[name])
+	}
+	return buf.Bytes()
+}
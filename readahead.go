@@ -0,0 +1,68 @@
+package main
+
+import "os"
+
+// readFile reads the files WalkAndFix processes.
+var readFile = os.ReadFile
+
+// prefetched is a file read ahead: data and err are set once done is
+// closed.
+type prefetched struct {
+	path string
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// readAhead reads the files WalkAndFix processes ahead of it, for
+// Config.ReadAhead: its reads overlap with each other and with the
+// parsing, which pays off on slow storage, like network filesystems.
+type readAhead struct {
+	files chan *prefetched
+	stop  chan struct{}
+}
+
+// startReadAhead starts reading the files of paths, found like
+// WalkAndFix finds them with cfg, up to n of them ahead of the ones
+// asked for by read.
+func startReadAhead(paths []string, cfg Config, n int) *readAhead {
+	ra := &readAhead{
+		files: make(chan *prefetched, n),
+		stop:  make(chan struct{}),
+	}
+	go func() {
+		defer close(ra.files)
+		walkFiles(paths, cfg, func(path string) bool {
+			p := &prefetched{path: path, done: make(chan struct{})}
+			select {
+			case ra.files <- p:
+			case <-ra.stop:
+				return false
+			}
+			go func() {
+				p.data, p.err = readFile(path)
+				close(p.done)
+			}()
+			return true
+		})
+	}()
+	return ra
+}
+
+// read returns the content of the file path. The files must be asked
+// for in the order they are walked: the ones read ahead before path,
+// skipped, are dropped. If path was not read ahead, it is read now.
+func (ra *readAhead) read(path string) ([]byte, error) {
+	for p := range ra.files {
+		if p.path == path {
+			<-p.done
+			return p.data, p.err
+		}
+	}
+	return readFile(path)
+}
+
+// close stops reading ahead.
+func (ra *readAhead) close() {
+	close(ra.stop)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// Result records the changes applied to a single file.
+type Result struct {
+	Filename string `json:"filename"`
+	// Calls counts the rewritten calls and value references,
+	// keyed by the original selector name (e.g. "ParseIP").
+	Calls map[string]int `json:"calls,omitempty"`
+	// Positions holds the position of every rewritten reference.
+	Positions []token.Position `json:"positions,omitempty"`
+	// ImportsAdded and ImportsRemoved hold the import paths
+	// added to and removed from the file.
+	ImportsAdded   []string `json:"importsAdded,omitempty"`
+	ImportsRemoved []string `json:"importsRemoved,omitempty"`
+	// Ignored holds the references skipped because of a
+	// //sloppy:ignore directive.
+	Ignored []Ignored `json:"ignored,omitempty"`
+	// Wrappers holds the functions of the file that only return
+	// the result of a rewritten call: their callers get the sloppy
+	// parsers too.
+	Wrappers []Wrapper `json:"wrappers,omitempty"`
+	// Warnings holds problems found in the file that did not
+	// prevent the rewrite.
+	Warnings []string `json:"warnings,omitempty"`
+	// Excluded is set by WalkAndFix for the files that do not
+	// match Config.Tags.
+	Excluded bool `json:"excluded,omitempty"`
+	// Generated is set by WalkAndFix for the generated files
+	// it skipped.
+	Generated bool `json:"generated,omitempty"`
+	// NotGo is set by WalkAndFix for the files given that do not end
+	// in .go, like the templates foo.go.tmpl, that it skipped.
+	NotGo bool `json:"notGo,omitempty"`
+
+	// edits holds the edits of the source, without its byte order
+	// mark, with noFormat.
+	edits []textEdit
+	// importNames holds the names the code refers to the added
+	// imports as, by path, for String.
+	importNames map[string]string
+	// bareEnds holds the ends of the references rewritten to a bare
+	// identifier, for noFormat, see Rule.samePackage.
+	bareEnds map[*ast.Ident]token.Pos
+}
+
+// Ignored is a reference skipped because of a //sloppy:ignore directive.
+type Ignored struct {
+	Name   string         `json:"name"`
+	Pos    token.Position `json:"pos"`
+	Reason string         `json:"reason,omitempty"`
+}
+
+// String returns a description of the skipped call, like
+//
+//	foo.go:12:7: ignored ParseIP: benchmark baseline
+func (i Ignored) String() string {
+	s := fmt.Sprintf("%s: ignored %s", i.Pos, i.Name)
+	if i.Reason != "" {
+		s += ": " + i.Reason
+	}
+	return s
+}
+
+// Wrapper is a function wrapping a rewritten call, like
+//
+//	func parseIP(s string) net.IP { return net.ParseIP(s) }
+type Wrapper struct {
+	Func string         `json:"func"`
+	Name string         `json:"name"`
+	Pos  token.Position `json:"pos"`
+	// Callers holds the position of the calls to the wrapper
+	// in the same file.
+	Callers []token.Position `json:"callers,omitempty"`
+}
+
+// String returns a description of the wrapper, like
+//
+//	foo.go:12:6: parseIP wraps ParseIP, its 2 callers in the file get the sloppy parser
+func (w Wrapper) String() string {
+	callers := "its callers get"
+	switch len(w.Callers) {
+	case 0:
+	case 1:
+		callers = "its caller in the file gets"
+	default:
+		callers = fmt.Sprintf("its %d callers in the file get", len(w.Callers))
+	}
+	return fmt.Sprintf("%s: %s wraps %s, %s the sloppy parser", w.Pos, w.Func, w.Name, callers)
+}
+
+// Changed reports whether r records any change.
+func (r *Result) Changed() bool {
+	return len(r.Calls) > 0 || len(r.ImportsAdded) > 0 || len(r.ImportsRemoved) > 0
+}
+
+// addCall records a rewritten reference to the selector name at pos.
+func (r *Result) addCall(name string, pos token.Position) {
+	if r.Calls == nil {
+		r.Calls = make(map[string]int)
+	}
+	r.Calls[name]++
+	r.Positions = append(r.Positions, pos)
+}
+
+// String returns a one-line summary of r, like
+//
+//	foo.go: +1 ParseCIDR, +2 ParseIP, -net, +netutils
+//
+// or "" if r records no change. Calls are sorted by name and
+// followed by the removed and the added imports, the latter by the
+// name the code refers to them as, or by path if it is not known.
+func (r *Result) String() string {
+	if !r.Changed() {
+		return ""
+	}
+	names := make([]string, 0, len(r.Calls))
+	for name := range r.Calls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("+%d %s", r.Calls[name], name))
+	}
+	for _, path := range r.ImportsRemoved {
+		parts = append(parts, "-"+path)
+	}
+	for _, path := range r.ImportsAdded {
+		if name := r.importNames[path]; name != "" {
+			path = name
+		}
+		parts = append(parts, "+"+path)
+	}
+	return r.Filename + ": " + strings.Join(parts, ", ")
+}
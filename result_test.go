@@ -0,0 +1,80 @@
+package main
+
+import (
+	"go/token"
+	"testing"
+)
+
+func TestResultString(t *testing.T) {
+	tests := []struct {
+		name string
+		res  Result
+		want string
+	}{
+		{
+			name: "no change",
+			res:  Result{Filename: "foo.go"},
+			want: "",
+		},
+		{
+			name: "calls and imports",
+			res: Result{
+				Filename:       "foo.go",
+				Calls:          map[string]int{"ParseIP": 2, "ParseCIDR": 1},
+				ImportsAdded:   []string{"k8s.io/utils/net"},
+				ImportsRemoved: []string{"net"},
+				importNames:    map[string]string{"k8s.io/utils/net": "netutils"},
+			},
+			want: "foo.go: +1 ParseCIDR, +2 ParseIP, -net, +netutils",
+		},
+		{
+			name: "calls only",
+			res: Result{
+				Filename: "bar.go",
+				Calls:    map[string]int{"ParseIP": 1},
+			},
+			want: "bar.go: +1 ParseIP",
+		},
+		{
+			name: "import without a known name",
+			res: Result{
+				Filename:     "baz.go",
+				ImportsAdded: []string{"k8s.io/utils/net"},
+			},
+			want: "baz.go: +k8s.io/utils/net",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.res.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResultAddCall(t *testing.T) {
+	var res Result
+	res.addCall("ParseIP", token.Position{Filename: "foo.go", Line: 3})
+	res.addCall("ParseIP", token.Position{Filename: "foo.go", Line: 7})
+	if got := res.Calls["ParseIP"]; got != 2 {
+		t.Errorf("Calls[ParseIP] = %d, want 2", got)
+	}
+	if len(res.Positions) != 2 || res.Positions[1].Line != 7 {
+		t.Errorf("unexpected positions %v", res.Positions)
+	}
+	if !res.Changed() {
+		t.Errorf("Changed() = false, want true")
+	}
+}
+
+func TestResultStringImportName(t *testing.T) {
+	in := "package main\n\nimport \"net\"\n\nvar netutils = 1\n\nvar ip = net.ParseIP(\"1.2.3.4\")\n"
+	_, res, err := Rewrite([]byte(in), "a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.String(), "a.go: +1 ParseIP, -net, +netutils2"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"io"
+	"os"
+)
+
+// utf8BOM is the byte order mark some editors put at the start of
+// UTF-8 files.
+var utf8BOM = []byte("\ufeff")
+
+// Rewrite applies the sloppy parsers fix to the Go source src,
+// read from filename, and returns the rewritten source and the
+// changes made. If nothing changed, src is returned as is.
+//
+// A leading byte order mark is dropped from the output, as gofmt does.
+func Rewrite(src []byte, filename string) ([]byte, Result, error) {
+	return rewrite(src, filename, sloppyRules)
+}
+
+// RewriteReader is like Rewrite, reading the source from r.
+func RewriteReader(r io.Reader, filename string) ([]byte, Result, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, Result{Filename: filename}, err
+	}
+	return Rewrite(src, filename)
+}
+
+// rewrite is like Rewrite, applying rules instead of sloppyRules.
+// The sources that cannot match any rule are returned unchanged
+// without being parsed, so their syntax errors are not reported.
+func rewrite(src []byte, filename string, rules []Rule) ([]byte, Result, error) {
+	if !mayMatch(src, rules) {
+		return src, Result{Filename: filename}, nil
+	}
+	return rewriteParsed(src, filename, rules)
+}
+
+// mayMatch reports whether src mentions the name of a rule, or an
+// ignore directive that may need to be reported as misplaced.
+func mayMatch(src []byte, rules []Rule) bool {
+	if bytes.Contains(src, []byte(ignoreDirective)) {
+		return true
+	}
+	for _, r := range rules {
+		if bytes.Contains(src, []byte(r.Name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// importsAny reports whether f imports the package of any rule.
+func importsAny(f *ast.File, rules []Rule) bool {
+	for _, r := range rules {
+		if importSpec(f, r.Path) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteParsed is rewrite without the mayMatch filter.
+func rewriteParsed(src []byte, filename string, rules []Rule) ([]byte, Result, error) {
+	res := Result{Filename: filename}
+	orig := src
+	src = bytes.TrimPrefix(src, utf8BOM)
+
+	file, err := parser.ParseFile(fset, filename, src, parserMode)
+	if err != nil {
+		return nil, res, err
+	}
+	// A file that does not import the package of any rule, under
+	// any name, cannot refer to its functions: a net.ParseIP selector
+	// there is not the standard library one. Its directives are all
+	// misplaced.
+	if !importsAny(file, rules) {
+		res.Warnings = misplacedDirectives(file, ignoreDirectives(file))
+		return orig, res, nil
+	}
+	if noFormat {
+		return rewriteInPlace(orig, src, file, filename, rules)
+	}
+
+	// Apply all fixes to file.
+	newFile := file
+	fixed := false
+
+	if applyRules(newFile, rules, &res) {
+		fixed = true
+
+		// AST changed.
+		// Print and parse, to update any missing scoping
+		// or position information for subsequent fixers.
+		newSrc, err := gofmtFile(newFile)
+		if err != nil {
+			return nil, res, fileError(filename, err)
+		}
+		newFile, err = parser.ParseFile(fset, filename, newSrc, parserMode)
+		if err != nil {
+			if debug {
+				fmt.Printf("%s", newSrc)
+				report(err)
+				os.Exit(exitCode)
+			}
+			return nil, res, err
+		}
+	}
+	if !fixed {
+		return orig, res, nil
+	}
+
+	// Print AST.  We did that after each fix, so this appears
+	// redundant, but it is necessary to generate gofmt-compatible
+	// source code in a few cases. The official gofmt style is the
+	// output of the printer run on a standard AST generated by the parser,
+	// but the source we generated inside the loop above is the
+	// output of the printer run on a mangled AST generated by a fixer.
+	fmtSrc, err := gofmtFile(newFile)
+	if err != nil {
+		return nil, res, fileError(filename, err)
+	}
+	// Sort and group the imports, the rules added and deleted them.
+	newSrc, err := fixImports(filename, fmtSrc)
+	if err != nil {
+		return nil, res, fileError(filename, err)
+	}
+	if formatOutput != nil {
+		if newSrc, err = formatOutput(newSrc); err != nil {
+			return nil, res, fileError(filename, err)
+		}
+	}
+	return newSrc, res, nil
+}
+
+// fileError adds filename to err, for the errors of the formatting
+// and of the import fixers: to the positions of a scanner.ErrorList
+// that lack it, or as a prefix to the other errors.
+func fileError(filename string, err error) error {
+	list, ok := err.(scanner.ErrorList)
+	if !ok {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	for _, e := range list {
+		if e.Pos.Filename == "" {
+			e.Pos.Filename = filename
+		}
+	}
+	return list
+}
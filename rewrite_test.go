@@ -0,0 +1,1067 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"go/ast"
+	"go/scanner"
+	"go/token"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRewriteBOM(t *testing.T) {
+	in := "\ufeffpackage main\n\nimport \"net\"\n\nfunc f() {\n\tc := net.ParseIP(\"ads\")\n}\n"
+	want := `package main
+
+import (
+	netutils "k8s.io/utils/net"
+)
+
+func f() {
+	c := netutils.ParseIPSloppy("ads")
+}
+`
+	out, res, err := Rewrite([]byte(in), "bom.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.HasPrefix(out, utf8BOM) {
+		t.Errorf("output starts with a byte order mark")
+	}
+	if string(out) != want {
+		t.Errorf("--- have\n%s\n--- want\n%s", out, want)
+	}
+	if res.Calls["ParseIP"] != 1 {
+		t.Errorf("Calls[ParseIP] = %d, want 1", res.Calls["ParseIP"])
+	}
+}
+
+func TestRewriteIgnoreTrailingDirective(t *testing.T) {
+	in := `package main
+
+import "net"
+
+func f() {
+	a := net.ParseIP("a") //sloppy:ignore
+	b := net.ParseIP("b")
+}
+`
+	out, res, err := Rewrite([]byte(in), "trailing.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Ignored) != 1 || res.Ignored[0].Pos.Line != 6 {
+		t.Fatalf("Ignored = %v, want only the call on line 6", res.Ignored)
+	}
+	if !bytes.Contains(out, []byte(`b := netutils.ParseIPSloppy("b")`)) {
+		t.Errorf("the call after a trailing directive was not rewritten:\n%s", out)
+	}
+}
+
+func TestRewriteIgnoreDirectives(t *testing.T) {
+	in := `package main
+
+import "net"
+
+func f() {
+	a := net.ParseIP("a") //sloppy:ignore
+	//sloppy:ignore(benchmark baseline)
+	b, _, err := net.ParseCIDR("b")
+
+	//sloppy:ignore(nothing here)
+
+	c := net.ParseIP("c")
+}
+`
+	_, res, err := Rewrite([]byte(in), "ignore.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Ignored) != 2 {
+		t.Fatalf("got %d ignored calls, want 2: %v", len(res.Ignored), res.Ignored)
+	}
+	if got, want := res.Ignored[0].String(), "ignore.go:6:7: ignored ParseIP"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := res.Ignored[1].String(), "ignore.go:8:15: ignored ParseCIDR: benchmark baseline"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if res.Calls["ParseIP"] != 1 || res.Calls["ParseCIDR"] != 0 {
+		t.Errorf("unexpected calls %v", res.Calls)
+	}
+	want := []string{"ignore.go:10:2: misplaced //sloppy:ignore directive, no matching call"}
+	if len(res.Warnings) != 1 || res.Warnings[0] != want[0] {
+		t.Errorf("Warnings = %q, want %q", res.Warnings, want)
+	}
+}
+
+func TestRewriteLineDirective(t *testing.T) {
+	in := `package main
+
+import "net"
+
+func f() {
+//line template.tmpl:100
+	c := net.ParseIP("ads") //sloppy:ignore
+	d := net.ParseIP("ads")
+}
+`
+	_, res, err := Rewrite([]byte(in), "gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Ignored) != 1 || res.Ignored[0].Pos.String() != "gen.go:7:7" {
+		t.Errorf("Ignored = %v, want a call at gen.go:7:7", res.Ignored)
+	}
+	if len(res.Positions) != 1 || res.Positions[0].String() != "gen.go:8:7" {
+		t.Errorf("Positions = %v, want [gen.go:8:7]", res.Positions)
+	}
+}
+
+func TestRewriteUnformattedPositions(t *testing.T) {
+	// gofmt collapses the blank lines and reindents the calls.
+	in := "package main\n\nimport \"net\"\n\n\n\nfunc f() {\n\n\n" +
+		"    c := net.ParseIP(\"ads\") //sloppy:ignore\n" +
+		"  d := net.ParseIP(\"ads\")\n}\n"
+	_, res, err := Rewrite([]byte(in), "a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Ignored) != 1 || res.Ignored[0].Pos.String() != "a.go:10:10" {
+		t.Errorf("Ignored = %v, want a call at a.go:10:10", res.Ignored)
+	}
+	if len(res.Positions) != 1 || res.Positions[0].String() != "a.go:11:8" {
+		t.Errorf("Positions = %v, want [a.go:11:8]", res.Positions)
+	}
+}
+
+func TestRewriteFormattingOnly(t *testing.T) {
+	for _, in := range []string{
+		"package main\n\nimport \"net\"\n\nvar ip net.IP",
+		"\n\n  package main\n\nimport \"net\"\n\nvar   ip net.IP\n\n\n",
+	} {
+		out, res, err := Rewrite([]byte(in), "a.go")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Changed() {
+			t.Errorf("%q reported as changed: %s", in, res.String())
+		}
+		if string(out) != in {
+			t.Errorf("%q rewritten to %q", in, out)
+		}
+	}
+}
+
+func TestRewriteNoMatch(t *testing.T) {
+	// Not valid Go, but nothing to rewrite either.
+	in := []byte("package main\n\nfunc f() {\n")
+	out, res, err := Rewrite(in, "a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Changed() || string(out) != string(in) {
+		t.Errorf("unexpected change %q: %s", out, res.String())
+	}
+}
+
+// benchmarkTree returns the sources of a tree where 1 in 20 files
+// calls net.ParseIP.
+func benchmarkTree() [][]byte {
+	var srcs [][]byte
+	for i := 0; i < 100; i++ {
+		src := cleanSrc
+		if i%20 == 0 {
+			src = dirtySrc
+		}
+		srcs = append(srcs, []byte(src))
+	}
+	return srcs
+}
+
+func BenchmarkRewriteTree(b *testing.B) {
+	srcs := benchmarkTree()
+	for _, bb := range []struct {
+		name    string
+		rewrite func([]byte, string, []Rule) ([]byte, Result, error)
+	}{
+		{"prefilter", rewrite},
+		{"parse", rewriteParsed},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, src := range srcs {
+					if _, _, err := bb.rewrite(src, "a.go", sloppyRules); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRewriteWithoutNetImport(t *testing.T) {
+	// The unformatted source shows the file is not even gofmt'ed.
+	in := "package main\n\nimport \"fmt\"\n\nfunc  f() { fmt.Println(net.ParseIP(\"ads\")) }\n"
+	out, res, err := Rewrite([]byte(in), "a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Changed() || string(out) != in {
+		t.Errorf("unexpected change %q: %s", out, res.String())
+	}
+}
+
+func TestRewriteImportChanges(t *testing.T) {
+	tests := map[string]struct {
+		added, removed []string
+	}{
+		"change net.ParseIP":                                                    {[]string{"k8s.io/utils/net"}, nil},
+		"change net.ParseIP and ParseCIDR":                                      {[]string{"k8s.io/utils/net"}, nil},
+		"change net.ParseIP and ParseCIDR and remove net":                       {[]string{"k8s.io/utils/net"}, []string{"net"}},
+		"existing netutils and change net.ParseIP and ParseCIDR and remove net": {nil, []string{"net"}},
+	}
+	for _, tc := range testCases {
+		want, ok := tests[tc.Name]
+		if !ok {
+			continue
+		}
+		delete(tests, tc.Name)
+		_, res, err := Rewrite([]byte(tc.In), "a.go")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(res.ImportsAdded, want.added) || !reflect.DeepEqual(res.ImportsRemoved, want.removed) {
+			t.Errorf("%s: added %q removed %q, want added %q removed %q",
+				tc.Name, res.ImportsAdded, res.ImportsRemoved, want.added, want.removed)
+		}
+	}
+	for name := range tests {
+		t.Errorf("no test case %q", name)
+	}
+}
+
+func TestRewriteDuplicateImports(t *testing.T) {
+	in := `package main
+
+import (
+	"net"
+	n "net"
+)
+
+func f() net.IP {
+	return n.ParseIP("b")
+}
+`
+	out, res, err := Rewrite([]byte(in), "a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `package main
+
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+func f() net.IP {
+	return netutils.ParseIPSloppy("b")
+}
+`
+	if string(out) != want {
+		t.Errorf("--- have\n%s\n--- want\n%s", out, want)
+	}
+	if len(res.ImportsRemoved) != 0 || !reflect.DeepEqual(res.ImportsAdded, []string{"k8s.io/utils/net"}) {
+		t.Errorf("added %q removed %q", res.ImportsAdded, res.ImportsRemoved)
+	}
+	wantWarning := `a.go:4:2: "net" is imported 2 times, the imports need manual cleanup`
+	if len(res.Warnings) != 1 || res.Warnings[0] != wantWarning {
+		t.Errorf("Warnings = %q, want %q", res.Warnings, wantWarning)
+	}
+}
+
+func TestRewriteKeepImports(t *testing.T) {
+	keepImports, fixImports = true, formatImports
+	defer func() { keepImports, fixImports = false, formatImports }()
+
+	in := `package main
+
+import "net"
+
+func f() {
+	a := net.ParseIP("a")
+}
+`
+	out, res, err := Rewrite([]byte(in), "a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `package main
+
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+func f() {
+	a := netutils.ParseIPSloppy("a")
+}
+`
+	if string(out) != want {
+		t.Errorf("--- have\n%s\n--- want\n%s", out, want)
+	}
+	if len(res.ImportsRemoved) != 0 {
+		t.Errorf("ImportsRemoved = %q, want none", res.ImportsRemoved)
+	}
+}
+
+func TestRewriteTestTable(t *testing.T) {
+	in := `package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  net.IP
+	}{
+		{input: "1.1.1.1", want: net.ParseIP("1.1.1.1")},
+		{input: "::1", want: net.ParseIP("::1")},
+		{
+			input: "10.0.0.1",
+			want:  net.ParseIP("10.0.0.1"),
+		},
+	}
+	for _, tt := range tests {
+		if got := parse(tt.input); !got.Equal(tt.want) {
+			t.Errorf("parse(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+`
+	out, res, err := Rewrite([]byte(in), "parse_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `package main
+
+import (
+	"net"
+	"testing"
+
+	netutils "k8s.io/utils/net"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  net.IP
+	}{
+		{input: "1.1.1.1", want: netutils.ParseIPSloppy("1.1.1.1")},
+		{input: "::1", want: netutils.ParseIPSloppy("::1")},
+		{
+			input: "10.0.0.1",
+			want:  netutils.ParseIPSloppy("10.0.0.1"),
+		},
+	}
+	for _, tt := range tests {
+		if got := parse(tt.input); !got.Equal(tt.want) {
+			t.Errorf("parse(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+`
+	if string(out) != want {
+		t.Errorf("--- have\n%s\n--- want\n%s", out, want)
+	}
+	if res.Calls["ParseIP"] != 3 {
+		t.Errorf("Calls = %v, want 3 ParseIP", res.Calls)
+	}
+}
+
+func TestRewriteReader(t *testing.T) {
+	want, wantRes, err := Rewrite([]byte(dirtySrc), "a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, res, err := RewriteReader(strings.NewReader(dirtySrc), "a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, want) {
+		t.Errorf("--- have\n%s\n--- want\n%s", out, want)
+	}
+	if !reflect.DeepEqual(res, wantRes) {
+		t.Errorf("got result %+v, want %+v", res, wantRes)
+	}
+}
+
+func TestRewriteImportBlockChurn(t *testing.T) {
+	in := `package main
+
+import (
+	"fmt"
+	"net"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+)
+
+var _ = fmt.Sprint(v1.PodSpec{}, metav1.Time{}, clientset.Interface(nil))
+
+func f() net.IP {
+	return net.ParseIP("1.2.3.4")
+}
+`
+	out, _, err := Rewrite([]byte(in), "a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// importLines returns the lines of the import block of src.
+	importLines := func(src string) []string {
+		start := strings.Index(src, "import (\n") + len("import (\n")
+		end := start + strings.Index(src[start:], "\n)")
+		return strings.Split(src[start:end], "\n")
+	}
+	have, orig := importLines(string(out)), importLines(in)
+	// gofmt does not align the import names, so the existing lines
+	// must be left untouched and the new import inserted alone.
+	want := append(orig, "\tnetutils \"k8s.io/utils/net\"")
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("import block:\n%s\nwant:\n%s", strings.Join(have, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRewriteSemicolons(t *testing.T) {
+	in := "package main\n\nimport \"net\"\n\nfunc f(x, y string) {\n\tip := net.ParseIP(x); _, n, err := net.ParseCIDR(y)\n\t_, _, _ = ip, n, err\n}\n"
+	want := `package main
+
+import (
+	netutils "k8s.io/utils/net"
+)
+
+func f(x, y string) {
+	ip := netutils.ParseIPSloppy(x)
+	_, n, err := netutils.ParseCIDRSloppy(y)
+	_, _, _ = ip, n, err
+}
+`
+	out, res, err := Rewrite([]byte(in), "a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != want {
+		t.Errorf("--- have\n%s\n--- want\n%s", out, want)
+	}
+	if len(res.Positions) != 2 {
+		t.Errorf("rewrote %d calls, want 2", len(res.Positions))
+	}
+
+	out2, res2, err := Rewrite(out, "a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out2, out) || res2.Changed() {
+		t.Errorf("second run changed the output:\n%s", out2)
+	}
+}
+
+func TestRewriteWrappers(t *testing.T) {
+	in := `package main
+
+import "net"
+
+func parseIP(s string) net.IP {
+	return net.ParseIP(s)
+}
+
+func parseIPs(a, b string) (net.IP, net.IP) {
+	return parseIP(a), parseIP(b)
+}
+
+func notWrapper(s string) net.IP {
+	ip := net.ParseIP(s)
+	return ip
+}
+`
+	_, res, err := Rewrite([]byte(in), "a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Wrappers) != 1 {
+		t.Fatalf("got wrappers %v, want parseIP", res.Wrappers)
+	}
+	w := res.Wrappers[0]
+	var callers []string
+	for _, pos := range w.Callers {
+		callers = append(callers, pos.String())
+	}
+	if !reflect.DeepEqual(callers, []string{"a.go:10:9", "a.go:10:21"}) {
+		t.Errorf("got callers %q", callers)
+	}
+	want := "a.go:5:6: parseIP wraps ParseIP, its 2 callers in the file get the sloppy parser"
+	if w.String() != want {
+		t.Errorf("got %q, want %q", w, want)
+	}
+}
+
+func TestRewriteErrorPositions(t *testing.T) {
+	_, _, err := Rewrite([]byte("package main\n\nfunc f() { net.ParseIP( }\n"), "a.go")
+	if err == nil || !strings.HasPrefix(err.Error(), "a.go:3:25: ") {
+		t.Errorf("got error %v, want it at a.go:3:25", err)
+	}
+
+	defer func() { fixImports = formatImports }()
+	var list scanner.ErrorList
+	list.Add(token.Position{Line: 4, Column: 2}, "broken imports")
+	for _, tt := range []struct {
+		err  error
+		want string
+	}{
+		{list, "a.go:4:2: broken imports"},
+		{errors.New("no space"), "a.go: no space"},
+	} {
+		fixErr := tt.err
+		fixImports = func(string, []byte) ([]byte, error) { return nil, fixErr }
+		_, _, err := Rewrite([]byte(dirtySrc), "a.go")
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("got error %v, want %q", err, tt.want)
+		}
+	}
+}
+
+func TestRewriteGoExperiment(t *testing.T) {
+	in := `//go:build goexperiment.rangefunc
+
+package main
+
+import "net"
+
+func ips(addrs []string) func(func(net.IP) bool) {
+	return func(yield func(net.IP) bool) {
+		for _, a := range addrs {
+			if !yield(net.ParseIP(a)) {
+				return
+			}
+		}
+	}
+}
+
+func first(addrs []string) net.IP {
+	for ip := range ips(addrs) {
+		return ip
+	}
+	return nil
+}
+`
+	out, res, err := Rewrite([]byte(in), "a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(strings.Replace(in, "net.ParseIP(a)", "netutils.ParseIPSloppy(a)", 1),
+		"import \"net\"\n", "import (\n\t\"net\"\n\n\tnetutils \"k8s.io/utils/net\"\n)\n", 1)
+	if string(out) != want {
+		t.Errorf("--- have\n%s\n--- want\n%s", out, want)
+	}
+	if res.Calls["ParseIP"] != 1 {
+		t.Errorf("Calls = %v, want 1 ParseIP", res.Calls)
+	}
+}
+
+func TestRewriteDuplicateAliases(t *testing.T) {
+	// Does not compile, as after a bad merge.
+	in := `package main
+
+import (
+	n "net"
+	net "net"
+)
+
+func f() {
+	a := net.ParseIP("a")
+	_, b, _ := n.ParseCIDR("b")
+}
+`
+	out, res, err := Rewrite([]byte(in), "a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `package main
+
+import (
+	netutils "k8s.io/utils/net"
+)
+
+func f() {
+	a := netutils.ParseIPSloppy("a")
+	_, b, _ := netutils.ParseCIDRSloppy("b")
+}
+`
+	if string(out) != want {
+		t.Errorf("--- have\n%s\n--- want\n%s", out, want)
+	}
+	if !reflect.DeepEqual(res.ImportsAdded, []string{"k8s.io/utils/net"}) || !reflect.DeepEqual(res.ImportsRemoved, []string{"net"}) {
+		t.Errorf("added %q removed %q", res.ImportsAdded, res.ImportsRemoved)
+	}
+	if len(res.Positions) != 2 {
+		t.Errorf("rewrote %d calls, want 2", len(res.Positions))
+	}
+}
+
+func TestRegisterRule(t *testing.T) {
+	defer func(rules []Rule) { sloppyRules = rules }(sloppyRules)
+	RegisterRule(Rule{
+		Path:        "strconv",
+		Name:        "Itoa",
+		TargetPath:  "example.com/conv",
+		TargetName:  "FormatInt",
+		TargetAlias: "conv",
+	})
+	RegisterRule(Rule{
+		Path:        "net",
+		Name:        "ParseMAC",
+		TargetPath:  "example.com/mac",
+		TargetName:  "Parse",
+		TargetAlias: "mac",
+		Match:       func(se *ast.SelectorExpr) bool { return false },
+	})
+
+	in := `package main
+
+import (
+	"net"
+	"strconv"
+)
+
+func f(i int) (net.IP, net.HardwareAddr, string) {
+	mac, _ := net.ParseMAC("00:00:5e:00:53:01")
+	return net.ParseIP("1.2.3.4"), mac, strconv.Itoa(i)
+}
+`
+	want := `package main
+
+import (
+	"net"
+
+	conv "example.com/conv"
+	netutils "k8s.io/utils/net"
+)
+
+func f(i int) (net.IP, net.HardwareAddr, string) {
+	mac, _ := net.ParseMAC("00:00:5e:00:53:01")
+	return netutils.ParseIPSloppy("1.2.3.4"), mac, conv.FormatInt(i)
+}
+`
+	out, res, err := Rewrite([]byte(in), "a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != want {
+		t.Errorf("--- have\n%s\n--- want\n%s", out, want)
+	}
+	if !reflect.DeepEqual(res.Calls, map[string]int{"Itoa": 1, "ParseIP": 1}) {
+		t.Errorf("Calls = %v, want Itoa and ParseIP", res.Calls)
+	}
+}
+
+func TestRewriteNoFormat(t *testing.T) {
+	noFormat = true
+	defer func() { noFormat = false }()
+
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "net removed",
+			in: `package main
+import "net"
+func f()  {
+    a:=net.ParseIP( "a" ) // parse
+	_, b, _ := net . /* cidr */ ParseCIDR("b")
+	var  x  =  1
+}
+`,
+			want: `package main
+import (
+	netutils "k8s.io/utils/net"
+)
+func f()  {
+    a:=netutils.ParseIPSloppy( "a" ) // parse
+	_, b, _ := netutils . /* cidr */ ParseCIDRSloppy("b")
+	var  x  =  1
+}
+`,
+		},
+		{
+			name: "net kept",
+			in: `package main
+
+import (
+	"fmt"
+	"net"
+)
+
+type  T  struct{ ip net.IP }
+
+func f()  { fmt.Println(net.ParseIP("a")) }
+`,
+			want: `package main
+
+import (
+	"fmt"
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+type  T  struct{ ip net.IP }
+
+func f()  { fmt.Println(netutils.ParseIPSloppy("a")) }
+`,
+		},
+		{
+			// gofmt would move the build constraint above the
+			// license block.
+			name: "license block above the build constraint",
+			in: `/*
+Copyright 2021 The Kubernetes Authors.
+*/
+
+//go:build linux
+
+package main
+
+import "net"
+
+var ip = net.ParseIP("1.2.3.4")
+`,
+			want: `/*
+Copyright 2021 The Kubernetes Authors.
+*/
+
+//go:build linux
+
+package main
+
+import (
+	netutils "k8s.io/utils/net"
+)
+
+var ip = netutils.ParseIPSloppy("1.2.3.4")
+`,
+		},
+		{
+			name: "nothing to rewrite",
+			in: `package main
+import "net"
+var  ip  net.IP
+`,
+			want: `package main
+import "net"
+var  ip  net.IP
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, _, err := Rewrite([]byte(tt.in), "a.go")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("--- have\n%s\n--- want\n%s", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteAlsoFromDotImport(t *testing.T) {
+	rules := alsoFrom(sloppyRules, []string{"example.com/internal/netx"})
+	in := `package main
+
+import . "example.com/internal/netx"
+
+type T struct{ ParseIP func(string) []byte }
+
+func f(s string) {
+	ParseIP(s)
+	t := T{ParseIP: ParseIP}
+	t.ParseIP(s)
+	ParseCIDR(s) //sloppy:ignore
+}
+
+func g(ParseIP func(string) []byte) {
+	ParseIP("1.2.3.4")
+}
+`
+	want := `package main
+
+import (
+	. "example.com/internal/netx"
+	netutils "k8s.io/utils/net"
+)
+
+type T struct{ ParseIP func(string) []byte }
+
+func f(s string) {
+	netutils.ParseIPSloppy(s)
+	t := T{ParseIP: netutils.ParseIPSloppy}
+	t.ParseIP(s)
+	ParseCIDR(s) //sloppy:ignore
+}
+
+func g(ParseIP func(string) []byte) {
+	ParseIP("1.2.3.4")
+}
+`
+	for _, nf := range []bool{false, true} {
+		noFormat = nf
+		out, res, err := rewrite([]byte(in), "a.go", rules)
+		noFormat = false
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != want {
+			t.Errorf("noFormat=%v:\n--- have\n%s\n--- want\n%s", nf, out, want)
+		}
+		if res.Calls["ParseIP"] != 2 || len(res.Ignored) != 1 {
+			t.Errorf("noFormat=%v: got %d ParseIP calls and %d ignored, want 2 and 1", nf, res.Calls["ParseIP"], len(res.Ignored))
+		}
+	}
+}
+
+func TestRewriteRenameExistingAlias(t *testing.T) {
+	const name = "existing netutils and change net.ParseIP and ParseCIDR and remove net"
+	var in, renamed string
+	for _, tc := range testCases {
+		if tc.Name == name {
+			in, renamed = tc.In, tc.Out
+		}
+	}
+	if in == "" {
+		t.Fatalf("no test case %q", name)
+	}
+	kept := `package main
+
+import (
+	utilnet "k8s.io/utils/net"
+)
+
+func f() {
+	c := utilnet.ParseIPSloppy("ads")
+	d, _, err := utilnet.ParseCIDRSloppy("ads")
+	utilnet.IsIPv6(d)
+}
+`
+	for _, rename := range []bool{true, false} {
+		want := renamed
+		if !rename {
+			want = kept
+		}
+		renameExistingAlias = rename
+		out, _, err := Rewrite([]byte(in), "a.go")
+		renameExistingAlias = true
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != want {
+			t.Errorf("renameExistingAlias=%v:\n--- have\n%s\n--- want\n%s", rename, out, want)
+		}
+	}
+}
+
+func TestRewriteBlankLines(t *testing.T) {
+	in := `package main
+
+import (
+	"fmt"
+	"net"
+)
+
+func f(s string) {
+	fmt.Println("before")
+
+	ip := net.ParseIP(s)
+
+	fmt.Println(ip)
+	// A comment, then a blank line.
+
+	if ip == nil {
+
+		_, _, _ = net.ParseCIDR(s)
+
+	}
+}
+`
+	want := strings.NewReplacer(
+		"\t\"net\"\n", "\n\tnetutils \"k8s.io/utils/net\"\n",
+		"net.ParseIP", "netutils.ParseIPSloppy",
+		"net.ParseCIDR", "netutils.ParseCIDRSloppy",
+	).Replace(in)
+	for _, nf := range []bool{false, true} {
+		noFormat = nf
+		out, _, err := Rewrite([]byte(in), "a.go")
+		var again []byte
+		var res Result
+		if err == nil {
+			again, res, err = Rewrite(out, "a.go")
+		}
+		noFormat = false
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != want {
+			t.Errorf("noFormat=%v:\n--- have\n%s\n--- want\n%s", nf, out, want)
+		}
+		if res.Changed() || string(again) != string(out) {
+			t.Errorf("noFormat=%v: second run changed the file:\n%s", nf, again)
+		}
+	}
+}
+
+func TestRewriteDeepNesting(t *testing.T) {
+	// nested returns a file calling net.ParseIP in n nested calls.
+	nested := func(n int) string {
+		return "package main\n\nimport \"net\"\n\nfunc f(ip net.IP) net.IP { return ip }\n\nvar ip = " +
+			strings.Repeat("f(", n) + `net.ParseIP("1.2.3.4")` + strings.Repeat(")", n) + "\n"
+	}
+	src := nested(10000)
+	out, res, err := Rewrite([]byte(src), "a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Calls["ParseIP"] != 1 || !strings.Contains(string(out), `(netutils.ParseIPSloppy("1.2.3.4"))`) {
+		t.Errorf("not rewritten: %s", res.String())
+	}
+
+	// Beyond the limit of go/parser, the file is reported, not
+	// rewritten.
+	_, _, err = Rewrite([]byte(nested(100000)), "a.go")
+	if err == nil || !strings.Contains(err.Error(), "a.go:7:") || !strings.Contains(err.Error(), "exceeded max nesting depth") {
+		t.Errorf("got error %v, want the nesting depth one", err)
+	}
+}
+
+func TestRewriteAliasedSourceAndTarget(t *testing.T) {
+	in := `package main
+
+import (
+	n "net"
+
+	nu "k8s.io/utils/net"
+)
+
+func f(s string) bool {
+	ip := n.ParseIP(s)
+	_, cidr, _ := n.ParseCIDR(s + "/24")
+	return nu.IsIPv6(ip) && cidr != nil
+}
+`
+	// With the alias kept, the rewritten calls use it too.
+	want := `package main
+
+import (
+	nu "k8s.io/utils/net"
+)
+
+func f(s string) bool {
+	ip := nu.ParseIPSloppy(s)
+	_, cidr, _ := nu.ParseCIDRSloppy(s + "/24")
+	return nu.IsIPv6(ip) && cidr != nil
+}
+`
+	tests := []struct {
+		rename bool
+		want   string
+	}{
+		{false, want},
+		{true, strings.ReplaceAll(want, "nu", "netutils")},
+	}
+	for _, tt := range tests {
+		renameExistingAlias = tt.rename
+		out, res, err := Rewrite([]byte(in), "a.go")
+		renameExistingAlias = true
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != tt.want {
+			t.Errorf("renameExistingAlias=%v:\n--- have\n%s\n--- want\n%s", tt.rename, out, tt.want)
+		}
+		if len(res.ImportsAdded) != 0 || !reflect.DeepEqual(res.ImportsRemoved, []string{"net"}) {
+			t.Errorf("renameExistingAlias=%v: added %q removed %q", tt.rename, res.ImportsAdded, res.ImportsRemoved)
+		}
+	}
+
+	// n stays imported while used.
+	in = strings.Replace(in, "func f(s string) bool {", "var _ n.IP\n\nfunc f(s string) bool {", 1)
+	renameExistingAlias = false
+	out, _, err := Rewrite([]byte(in), "a.go")
+	renameExistingAlias = true
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "\tn \"net\"\n") || !strings.Contains(string(out), "nu.ParseIPSloppy(s)") {
+		t.Errorf("n import removed or calls not rewritten:\n%s", out)
+	}
+}
+
+func TestRewriteUnicodeAliases(t *testing.T) {
+	in := `package main
+
+import (
+	réseau "net"
+
+	ネット "k8s.io/utils/net"
+)
+
+func f(s string) bool {
+	return ネット.IsIPv4(réseau.ParseIP(s))
+}
+`
+	want := `package main
+
+import (
+	ネット "k8s.io/utils/net"
+)
+
+func f(s string) bool {
+	return ネット.IsIPv4(ネット.ParseIPSloppy(s))
+}
+`
+	renameExistingAlias = false
+	defer func() { renameExistingAlias = true }()
+	for _, nf := range []bool{false, true} {
+		noFormat = nf
+		out, _, err := Rewrite([]byte(in), "a.go")
+		noFormat = false
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != want {
+			t.Errorf("noFormat=%v:\n--- have\n%s\n--- want\n%s", nf, out, want)
+		}
+	}
+}
+
+func TestRewriteMisplacedDirectiveWithoutImport(t *testing.T) {
+	in := "package main\n\n//sloppy:ignore(stale)\nvar ip = parse(\"1.2.3.4\")\n"
+	out, res, err := Rewrite([]byte(in), "stale.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != in {
+		t.Errorf("rewritten to:\n%s", out)
+	}
+	want := "stale.go:3:1: misplaced //sloppy:ignore directive, no matching call"
+	if len(res.Warnings) != 1 || res.Warnings[0] != want {
+		t.Errorf("Warnings = %q, want [%q]", res.Warnings, want)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// riskyCall is a reference to a strict parser that may behave
+// differently once rewritten, for -report-only-risky.
+type riskyCall struct {
+	pos token.Position
+	// call is the call, like ParseIP("010.0.0.1").
+	call string
+	why  string
+	// unknown is set when the behavior cannot be compared, and why
+	// tells why.
+	unknown bool
+}
+
+// riskyCalls holds the references found by collectRisky, in order.
+var riskyCalls []riskyCall
+
+// compareParsers maps the names of the net parsers to a function
+// returning the results of the strict and of the sloppy parser for s,
+// formatted, for the literals of collectRisky.
+var compareParsers = map[string]func(s string) (strict, sloppy string){
+	"ParseIP": func(s string) (string, string) {
+		return ipResult(strictParseIP(s)), ipResult(sloppyParseIP(s))
+	},
+	"ParseCIDR": func(s string) (string, string) {
+		return cidrResult(strictParseCIDR(s)), cidrResult(sloppyParseCIDR(s))
+	},
+}
+
+// collectRisky records in riskyCalls the references to the net
+// parsers of rules in the Go source src, read from filename, that may
+// not behave the same once rewritten: the calls with a literal parsed
+// differently by the sloppy parser, and the calls with another
+// argument, or the references that are not calls, whose behavior is
+// unknown.
+func collectRisky(src []byte, filename string, rules []Rule) error {
+	f, err := parser.ParseFile(fset, filename, src, parserMode)
+	if err != nil {
+		return err
+	}
+	byName := rulesByName(f, rules)
+	if len(byName) == 0 {
+		return nil
+	}
+	// calls holds the selectors called, seen before the selectors
+	// themselves.
+	calls := make(map[*ast.SelectorExpr]bool)
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			se, r := ruleSelector(n.Fun, byName)
+			compare := comparer(r)
+			if compare == nil {
+				return true
+			}
+			calls[se] = true
+			call := riskyCall{pos: position(n.Pos())}
+			if lit := literalArg(n); lit != nil {
+				s, err := strconv.Unquote(lit.Value)
+				if err != nil {
+					return true
+				}
+				call.call = fmt.Sprintf("%s(%s)", r.Name, lit.Value)
+				strict, sloppy := compare(s)
+				if strict == sloppy {
+					return true
+				}
+				call.why = fmt.Sprintf("returns %s once rewritten, instead of %s", sloppy, strict)
+			} else {
+				call.call = r.Name + "(...)"
+				call.why, call.unknown = "the argument is not a literal", true
+			}
+			riskyCalls = append(riskyCalls, call)
+		case *ast.SelectorExpr:
+			if _, r := ruleSelector(n, byName); comparer(r) != nil && !calls[n] {
+				riskyCalls = append(riskyCalls, riskyCall{
+					pos:     position(n.Pos()),
+					call:    r.Name,
+					why:     "a reference that is not a call",
+					unknown: true,
+				})
+			}
+		}
+		return true
+	})
+	return nil
+}
+
+// comparer returns the function of compareParsers for the net parser
+// of r, or nil if r is nil or rewrites another function.
+func comparer(r *Rule) func(s string) (strict, sloppy string) {
+	if r == nil || r.Path != "net" {
+		return nil
+	}
+	return compareParsers[r.Name]
+}
+
+// literalArg returns the argument of call, if it is its only one and
+// a string literal.
+func literalArg(call *ast.CallExpr) *ast.BasicLit {
+	if len(call.Args) != 1 {
+		return nil
+	}
+	lit, ok := call.Args[0].(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return nil
+	}
+	return lit
+}
+
+// printRisky prints the references collected to w: the calls that
+// change behavior first, then the ones whose behavior is unknown, like
+//
+//	a.go:6:6: ParseIP("010.0.0.1") returns 10.0.0.1 once rewritten, instead of nil
+//	a.go:9:6: unknown risk: ParseIP(...), the argument is not a literal
+func printRisky(w io.Writer) {
+	for _, c := range riskyCalls {
+		if !c.unknown {
+			fmt.Fprintf(w, "%s: %s %s\n", c.pos, c.call, c.why)
+		}
+	}
+	for _, c := range riskyCalls {
+		if c.unknown {
+			fmt.Fprintf(w, "%s: unknown risk: %s, %s\n", c.pos, c.call, c.why)
+		}
+	}
+}
+
+// strictParseIP is net.ParseIP rejecting the leading zeros in the IPv4
+// fields, as it does since Go 1.17: built with an older Go, whose
+// net.ParseIP accepts them, the strict and the sloppy parsers would
+// not differ.
+func strictParseIP(s string) net.IP {
+	if trimIPv4Zeros(s) != s {
+		return nil
+	}
+	return net.ParseIP(s)
+}
+
+// strictParseCIDR is net.ParseCIDR rejecting the leading zeros in the
+// IPv4 fields, like strictParseIP. The prefix length may have some.
+func strictParseCIDR(s string) (net.IP, *net.IPNet, error) {
+	if i := strings.LastIndexByte(s, '/'); i >= 0 && trimIPv4Zeros(s[:i]) != s[:i] {
+		return nil, nil, &net.ParseError{Type: "CIDR address", Text: s}
+	}
+	return net.ParseCIDR(s)
+}
+
+// sloppyParseIP is net.ParseIP accepting leading zeros in the IPv4
+// fields, read as decimal, like k8s.io/utils/net.ParseIPSloppy.
+func sloppyParseIP(s string) net.IP {
+	return net.ParseIP(trimIPv4Zeros(s))
+}
+
+// sloppyParseCIDR is net.ParseCIDR accepting leading zeros in the IPv4
+// fields, like k8s.io/utils/net.ParseCIDRSloppy. Both accept them in
+// the prefix length.
+func sloppyParseCIDR(s string) (net.IP, *net.IPNet, error) {
+	if i := strings.LastIndexByte(s, '/'); i >= 0 {
+		s = trimIPv4Zeros(s[:i]) + s[i:]
+	}
+	return net.ParseCIDR(s)
+}
+
+// trimIPv4Zeros removes the leading zeros of the fields of the IPv4
+// address s, or of the IPv4 suffix of the IPv6 address s.
+func trimIPv4Zeros(s string) string {
+	i := strings.LastIndexByte(s, ':') + 1
+	if !strings.Contains(s[i:], ".") {
+		return s
+	}
+	fields := strings.Split(s[i:], ".")
+	for j, f := range fields {
+		for len(f) > 1 && f[0] == '0' {
+			f = f[1:]
+		}
+		fields[j] = f
+	}
+	return s[:i] + strings.Join(fields, ".")
+}
+
+// ipResult formats the result of a ParseIP call.
+func ipResult(ip net.IP) string {
+	if ip == nil {
+		return "nil"
+	}
+	return ip.String()
+}
+
+// cidrResult formats the results of a ParseCIDR call.
+func cidrResult(ip net.IP, ipNet *net.IPNet, err error) string {
+	if err != nil {
+		return "an error"
+	}
+	return ip.String() + ", " + ipNet.String()
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestSloppyParsers(t *testing.T) {
+	tests := []struct {
+		name, in       string
+		strict, sloppy string
+	}{
+		{"ParseIP", "1.2.3.4", "1.2.3.4", "1.2.3.4"},
+		{"ParseIP", "0.0.0.0", "0.0.0.0", "0.0.0.0"},
+		{"ParseIP", "10.0.0.10", "10.0.0.10", "10.0.0.10"},
+		{"ParseIP", "010.0.0.1", "nil", "10.0.0.1"},
+		{"ParseIP", "000.0.0.00", "nil", "0.0.0.0"},
+		{"ParseIP", "::ffff:010.1.2.3", "nil", "10.1.2.3"},
+		{"ParseIP", "2001:db8::01", "2001:db8::1", "2001:db8::1"},
+		{"ParseIP", "256.0.0.1", "nil", "nil"},
+		{"ParseIP", "0256.0.0.1", "nil", "nil"},
+		{"ParseIP", "1.2.3", "nil", "nil"},
+		{"ParseCIDR", "10.0.0.0/8", "10.0.0.0, 10.0.0.0/8", "10.0.0.0, 10.0.0.0/8"},
+		{"ParseCIDR", "010.0.0.0/8", "an error", "10.0.0.0, 10.0.0.0/8"},
+		{"ParseCIDR", "1.2.3.4/024", "1.2.3.4, 1.2.3.0/24", "1.2.3.4, 1.2.3.0/24"},
+		{"ParseCIDR", "1.2.3.4/033", "an error", "an error"},
+		{"ParseCIDR", "1.2.3.4/33", "an error", "an error"},
+		{"ParseCIDR", "1.2.3.4", "an error", "an error"},
+	}
+	for _, tt := range tests {
+		strict, sloppy := compareParsers[tt.name](tt.in)
+		if strict != tt.strict || sloppy != tt.sloppy {
+			t.Errorf("%s(%q) = %q strict, %q sloppy, want %q, %q", tt.name, tt.in, strict, sloppy, tt.strict, tt.sloppy)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// Rule rewrites the references to a function of a package
+// to a function of another package.
+type Rule struct {
+	// Path and Name are the import path and the name of the
+	// function to rewrite, like "net" and "ParseIP".
+	Path, Name string
+	// TargetPath and TargetName are the import path and the name
+	// of the replacement, like "k8s.io/utils/net" and "ParseIPSloppy".
+	TargetPath, TargetName string
+	// TargetAlias is the name TargetPath is imported as.
+	TargetAlias string
+	// Match, if not nil, restricts the references rewritten to the
+	// selectors it reports true for.
+	Match func(se *ast.SelectorExpr) bool
+
+	// samePackage is set by WalkAndFix when TargetPath is the package
+	// of the file, that declares TargetName: the references are
+	// rewritten to the bare TargetName, without import.
+	samePackage bool
+	// selfImport is set instead when the package does not declare
+	// TargetName: the references are left alone, with a warning.
+	selfImport bool
+}
+
+// sloppyRules are the rules applied by Rewrite, and by WalkAndFix
+// without Config.Rules: the built-in ones, registered below, replace
+// the strict net parsers with their sloppy counterparts in
+// k8s.io/utils/net.
+var sloppyRules []Rule
+
+// RegisterRule adds r to the rules applied by Rewrite, and by
+// WalkAndFix without Config.Rules. It is meant to be called from init
+// functions, it is not safe to call it concurrently with a rewrite.
+func RegisterRule(r Rule) {
+	sloppyRules = append(sloppyRules, r)
+}
+
+// alsoFrom returns rules with, for each rule of the net package, a
+// copy for each of paths: the packages wrapping the strict net parsers
+// under the same names, for -also-from.
+func alsoFrom(rules []Rule, paths []string) []Rule {
+	out := append([]Rule(nil), rules...)
+	for _, path := range paths {
+		for _, r := range rules {
+			if r.Path == "net" {
+				r.Path = path
+				out = append(out, r)
+			}
+		}
+	}
+	return out
+}
+
+// printRules prints rules to w as a table, for -dump-rules, sorted so
+// the same rules always print the same, like
+//
+//	PACKAGE  NAME     TARGET            NAME           ALIAS
+//	net      ParseIP  k8s.io/utils/net  ParseIPSloppy  netutils
+//
+// The rules restricted by a Match function end with a *.
+func printRules(w io.Writer, rules []Rule) error {
+	sorted := append([]Rule(nil), rules...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		switch {
+		case a.Path != b.Path:
+			return a.Path < b.Path
+		case a.Name != b.Name:
+			return a.Name < b.Name
+		case a.TargetPath != b.TargetPath:
+			return a.TargetPath < b.TargetPath
+		default:
+			return a.TargetName < b.TargetName
+		}
+	})
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PACKAGE\tNAME\tTARGET\tNAME\tALIAS")
+	for _, r := range sorted {
+		match := ""
+		if r.Match != nil {
+			match = " *"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s%s\n", r.Path, r.Name, r.TargetPath, r.TargetName, r.TargetAlias, match)
+	}
+	return tw.Flush()
+}
+
+func init() {
+	RegisterRule(Rule{
+		Path:        "net",
+		Name:        "ParseIP",
+		TargetPath:  "k8s.io/utils/net",
+		TargetName:  "ParseIPSloppy",
+		TargetAlias: "netutils",
+	})
+	RegisterRule(Rule{
+		Path:        "net",
+		Name:        "ParseCIDR",
+		TargetPath:  "k8s.io/utils/net",
+		TargetName:  "ParseCIDRSloppy",
+		TargetAlias: "netutils",
+	})
+}
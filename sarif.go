@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"unicode/utf8"
+)
+
+// The subset of the SARIF 2.1.0 format describing the fixes
+// of the results.
+type (
+	sarifLog struct {
+		Runs []struct {
+			OriginalURIBaseIDs map[string]sarifArtifactLocation `json:"originalUriBaseIds"`
+			ColumnKind         string                           `json:"columnKind"`
+			Results            []struct {
+				Fixes []struct {
+					ArtifactChanges []sarifArtifactChange `json:"artifactChanges"`
+				} `json:"fixes"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	sarifArtifactLocation struct {
+		URI       string `json:"uri"`
+		URIBaseID string `json:"uriBaseId"`
+	}
+	sarifArtifactChange struct {
+		ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+		Replacements     []sarifReplacement    `json:"replacements"`
+	}
+	sarifReplacement struct {
+		DeletedRegion   sarifRegion `json:"deletedRegion"`
+		InsertedContent struct {
+			Text string `json:"text"`
+		} `json:"insertedContent"`
+		// codePoints is set if the columns of the region count
+		// Unicode code points, instead of UTF-16 code units.
+		codePoints bool
+	}
+	sarifRegion struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn"`
+		EndLine     int `json:"endLine"`
+		EndColumn   int `json:"endColumn"`
+		Snippet     struct {
+			Text string `json:"text"`
+		} `json:"snippet"`
+	}
+)
+
+// applySARIF applies the fixes of the SARIF log in the file sarifFile
+// to the files they refer to. Relative URIs are resolved through the
+// uriBaseId of their location and the originalUriBaseIds of the run,
+// or against the current directory if the run does not define their
+// base. Columns are counted in UTF-16 code units, the SARIF default,
+// unless the columnKind of the run is unicodeCodePoints.
+//
+// The text of each deleted region must be given as its snippet, and
+// match the current content of the file: the replacements of a file
+// that changed since the log was produced are skipped with a warning,
+// like the files that cannot be read. The replaced text is written as
+// it is, without formatting the rest of the file. It returns an error
+// if the log cannot be read or a file written.
+func applySARIF(sarifFile string) error {
+	data, err := os.ReadFile(sarifFile)
+	if err != nil {
+		return err
+	}
+	var log sarifLog
+	if err := json.Unmarshal(data, &log); err != nil {
+		return fmt.Errorf("%s: %v", sarifFile, err)
+	}
+
+	// Group the replacements by file, in order of appearance.
+	var files []string
+	changes := make(map[string][]sarifReplacement)
+	for _, run := range log.Runs {
+		var codePoints bool
+		switch run.ColumnKind {
+		case "", "utf16CodeUnits":
+		case "unicodeCodePoints":
+			codePoints = true
+		default:
+			return fmt.Errorf("%s: unsupported columnKind %q", sarifFile, run.ColumnKind)
+		}
+		for _, result := range run.Results {
+			for _, fix := range result.Fixes {
+				for _, ac := range fix.ArtifactChanges {
+					path, err := sarifPath(ac.ArtifactLocation, run.OriginalURIBaseIDs, nil)
+					if err != nil {
+						return err
+					}
+					if _, ok := changes[path]; !ok {
+						files = append(files, path)
+					}
+					for _, r := range ac.Replacements {
+						r.codePoints = codePoints
+						changes[path] = append(changes[path], r)
+					}
+				}
+			}
+		}
+	}
+
+	for _, path := range files {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %v, skipped\n", err)
+			continue
+		}
+		out, err := applyReplacements(src, changes[path])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %s: %v, skipped\n", path, err)
+			continue
+		}
+		if err := writeFile(path, out); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%s: applied %d replacements\n", path, len(changes[path]))
+	}
+	return nil
+}
+
+// sarifPath returns the file path of the artifact location loc. A
+// relative uri is joined to the location of its uriBaseId in bases,
+// resolved the same way, and left relative to the current directory
+// if bases does not define it. seen holds the base ids being resolved.
+func sarifPath(loc sarifArtifactLocation, bases map[string]sarifArtifactLocation, seen map[string]bool) (string, error) {
+	u, err := url.Parse(loc.URI)
+	if err != nil {
+		return "", fmt.Errorf("invalid artifact uri %q: %v", loc.URI, err)
+	}
+	if u.Scheme != "" && u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported artifact uri %q", loc.URI)
+	}
+	path := filepath.FromSlash(u.Path)
+	if u.Scheme == "file" || filepath.IsAbs(path) {
+		return path, nil
+	}
+	base, ok := bases[loc.URIBaseID]
+	if loc.URIBaseID == "" || !ok {
+		return path, nil
+	}
+	if seen[loc.URIBaseID] {
+		return "", fmt.Errorf("uriBaseId %q refers to itself", loc.URIBaseID)
+	}
+	if seen == nil {
+		seen = make(map[string]bool)
+	}
+	seen[loc.URIBaseID] = true
+	dir, err := sarifPath(base, bases, seen)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, path), nil
+}
+
+// applyReplacements returns src with the replacements applied. It fails
+// if a deleted region is out of range, overlaps another, or does not
+// hold its snippet.
+func applyReplacements(src []byte, replacements []sarifReplacement) ([]byte, error) {
+	type edit struct {
+		start, end int
+		text       string
+	}
+	var edits []edit
+	for _, r := range replacements {
+		region := r.DeletedRegion
+		start, ok1 := lineColOffset(src, region.StartLine, region.StartColumn, r.codePoints)
+		end, ok2 := lineColOffset(src, region.EndLine, region.EndColumn, r.codePoints)
+		if !ok1 || !ok2 || end < start {
+			return nil, fmt.Errorf("region %d:%d-%d:%d out of range",
+				region.StartLine, region.StartColumn, region.EndLine, region.EndColumn)
+		}
+		if got := string(src[start:end]); got != region.Snippet.Text {
+			return nil, fmt.Errorf("content at %d:%d is %q, expected %q",
+				region.StartLine, region.StartColumn, got, region.Snippet.Text)
+		}
+		edits = append(edits, edit{start, end, r.InsertedContent.Text})
+	}
+
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	var buf bytes.Buffer
+	last := 0
+	for _, e := range edits {
+		if e.start < last {
+			return nil, fmt.Errorf("overlapping replacements")
+		}
+		buf.Write(src[last:e.start])
+		buf.WriteString(e.text)
+		last = e.end
+	}
+	buf.Write(src[last:])
+	return buf.Bytes(), nil
+}
+
+// lineColOffset returns the byte offset of the 1-based line and column
+// in src. The column counts UTF-16 code units, or Unicode code points
+// if codePoints is set, and may be one past the end of the line.
+func lineColOffset(src []byte, line, col int, codePoints bool) (int, bool) {
+	if line < 1 || col < 1 {
+		return 0, false
+	}
+	offset := 0
+	for l := 1; l < line; l++ {
+		i := bytes.IndexByte(src[offset:], '\n')
+		if i < 0 {
+			return 0, false
+		}
+		offset += i + 1
+	}
+	end := len(src)
+	if i := bytes.IndexByte(src[offset:], '\n'); i >= 0 {
+		end = offset + i + 1
+	}
+	for n := col - 1; n > 0; {
+		if offset >= end {
+			return 0, false
+		}
+		r, size := utf8.DecodeRune(src[offset:end])
+		units := 1
+		if !codePoints && r > 0xffff {
+			// Encoded as a surrogate pair, that cannot be split.
+			units = 2
+		}
+		if units > n {
+			return 0, false
+		}
+		n -= units
+		offset += size
+	}
+	return offset, true
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sarifFixture = `{
+  "version": "2.1.0",
+  "runs": [{
+    "originalUriBaseIds": {"SRCROOT": {"uri": "%SRCROOT%"}},
+    "results": [
+      {
+        "ruleId": "sloppy-netparsers",
+        "fixes": [{
+          "artifactChanges": [{
+            "artifactLocation": {"uri": "a.go", "uriBaseId": "SRCROOT"},
+            "replacements": [
+              {
+                "deletedRegion": {"startLine": 3, "startColumn": 8, "endLine": 3, "endColumn": 13, "snippet": {"text": "\"net\""}},
+                "insertedContent": {"text": "(\n\t\"net\"\n\n\tnetutils \"k8s.io/utils/net\"\n)"}
+              },
+              {
+                "deletedRegion": {"startLine": 6, "startColumn": 9, "endLine": 6, "endColumn": 20, "snippet": {"text": "net.ParseIP"}},
+                "insertedContent": {"text": "netutils.ParseIPSloppy"}
+              }
+            ]
+          }]
+        }]
+      },
+      {
+        "ruleId": "sloppy-netparsers",
+        "fixes": [{
+          "artifactChanges": [{
+            "artifactLocation": {"uri": "sub/b.go", "uriBaseId": "SRCROOT"},
+            "replacements": [
+              {
+                "deletedRegion": {"startLine": 6, "startColumn": 9, "endLine": 6, "endColumn": 20, "snippet": {"text": "net.ParseIP"}},
+                "insertedContent": {"text": "netutils.ParseIPSloppy"}
+              }
+            ]
+          }]
+        }]
+      },
+      {
+        "ruleId": "sloppy-netparsers",
+        "fixes": [{
+          "artifactChanges": [{
+            "artifactLocation": {"uri": "c.go", "uriBaseId": "SRCROOT"},
+            "replacements": [
+              {
+                "deletedRegion": {"startLine": 5, "startColumn": 12, "endLine": 5, "endColumn": 23, "snippet": {"text": "net.ParseIP"}},
+                "insertedContent": {"text": "net.ParseIPv4"}
+              }
+            ]
+          }]
+        }]
+      },
+      {
+        "ruleId": "sloppy-netparsers",
+        "fixes": [{
+          "artifactChanges": [{
+            "artifactLocation": {"uri": "gone.go", "uriBaseId": "SRCROOT"},
+            "replacements": [
+              {
+                "deletedRegion": {"startLine": 6, "startColumn": 9, "endLine": 6, "endColumn": 20, "snippet": {"text": "net.ParseIP"}},
+                "insertedContent": {"text": "netutils.ParseIPSloppy"}
+              }
+            ]
+          }]
+        }]
+      }
+    ]
+  }]
+}
+`
+
+func TestApplySARIF(t *testing.T) {
+	// sub/b.go changed since the log was produced, c.go is not gofmt
+	// clean and gone.go was deleted.
+	drifted := "package main\n\nimport \"net\"\n\nfunc f() net.IP {\n\treturn nil\n}\n"
+	unformatted := "package main\n\nimport \"net\"\n\nvar ip  =  net.ParseIP(\"1.2.3.4\")\n"
+	dir := writeFiles(t, map[string]string{
+		"a.go":     dirtySrc,
+		"sub/b.go": drifted,
+		"c.go":     unformatted,
+	})
+	// The log is not next to the files, they are found through SRCROOT.
+	root := (&url.URL{Scheme: "file", Path: filepath.ToSlash(dir) + "/"}).String()
+	sarifFile := filepath.Join(t.TempDir(), "fixes.sarif")
+	if err := os.WriteFile(sarifFile, []byte(strings.Replace(sarifFixture, "%SRCROOT%", root, 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := applySARIF(sarifFile); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `package main
+
+import (
+	"net"
+
+	netutils "k8s.io/utils/net"
+)
+
+func f() net.IP {
+	return netutils.ParseIPSloppy("1.2.3.4")
+}
+`
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.go")); string(b) != want {
+		t.Errorf("--- have\n%s\n--- want\n%s", b, want)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "sub", "b.go")); string(b) != drifted {
+		t.Errorf("drifted file modified:\n%s", b)
+	}
+	want = strings.Replace(unformatted, "net.ParseIP", "net.ParseIPv4", 1)
+	if b, _ := os.ReadFile(filepath.Join(dir, "c.go")); string(b) != want {
+		t.Errorf("--- have\n%s\n--- want\n%s", b, want)
+	}
+}
+
+func TestApplyReplacements(t *testing.T) {
+	src := []byte("abc\ndef\né😀xy\n")
+	tests := []struct {
+		name         string
+		replacements []sarifReplacement
+		want         string
+		ok           bool
+	}{
+		{"none", nil, "abc\ndef\né😀xy\n", true},
+		{"replace", []sarifReplacement{replacement(2, 1, 2, 3, "de", "XY")}, "abc\nXYf\né😀xy\n", true},
+		{"insert at end of line", []sarifReplacement{replacement(1, 4, 1, 4, "", "!")}, "abc!\ndef\né😀xy\n", true},
+		{"drifted", []sarifReplacement{replacement(1, 1, 1, 3, "xy", "XY")}, "", false},
+		{"out of range", []sarifReplacement{replacement(6, 1, 6, 2, "a", "b")}, "", false},
+		{"utf-16 columns", []sarifReplacement{replacement(3, 4, 3, 5, "x", "X")}, "abc\ndef\né😀Xy\n", true},
+		{"split surrogate pair", []sarifReplacement{replacement(3, 3, 3, 4, "x", "X")}, "", false},
+		{"code point columns", []sarifReplacement{codePoints(replacement(3, 3, 3, 4, "x", "X"))}, "abc\ndef\né😀Xy\n", true},
+		{"overlapping", []sarifReplacement{replacement(1, 1, 1, 3, "ab", "X"), replacement(1, 2, 1, 4, "bc", "Y")}, "", false},
+	}
+	for _, tt := range tests {
+		out, err := applyReplacements(src, tt.replacements)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if tt.ok && string(out) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, out, tt.want)
+		}
+	}
+}
+
+func replacement(startLine, startCol, endLine, endCol int, deleted, inserted string) sarifReplacement {
+	var r sarifReplacement
+	r.DeletedRegion = sarifRegion{StartLine: startLine, StartColumn: startCol, EndLine: endLine, EndColumn: endCol}
+	r.DeletedRegion.Snippet.Text = deleted
+	r.InsertedContent.Text = inserted
+	return r
+}
+
+func codePoints(r sarifReplacement) sarifReplacement {
+	r.codePoints = true
+	return r
+}
+
+func TestSarifPath(t *testing.T) {
+	bases := map[string]sarifArtifactLocation{
+		"ROOT": {URI: "file:///src/"},
+		"PKG":  {URI: "pkg/", URIBaseID: "ROOT"},
+		"LOOP": {URI: "loop/", URIBaseID: "LOOP"},
+	}
+	tests := []struct {
+		loc  sarifArtifactLocation
+		want string
+		ok   bool
+	}{
+		{sarifArtifactLocation{URI: "a.go", URIBaseID: "ROOT"}, "/src/a.go", true},
+		{sarifArtifactLocation{URI: "a.go", URIBaseID: "PKG"}, "/src/pkg/a.go", true},
+		{sarifArtifactLocation{URI: "sub/a.go"}, "sub/a.go", true},
+		{sarifArtifactLocation{URI: "a.go", URIBaseID: "%SRCROOT%"}, "a.go", true},
+		{sarifArtifactLocation{URI: "file:///abs/a.go", URIBaseID: "ROOT"}, "/abs/a.go", true},
+		{sarifArtifactLocation{URI: "a.go", URIBaseID: "LOOP"}, "", false},
+		{sarifArtifactLocation{URI: "https://example.com/a.go"}, "", false},
+	}
+	for _, tt := range tests {
+		got, err := sarifPath(tt.loc, bases, nil)
+		if (err == nil) != tt.ok {
+			t.Errorf("%+v: unexpected error %v", tt.loc, err)
+			continue
+		}
+		if tt.ok && got != filepath.FromSlash(tt.want) {
+			t.Errorf("%+v: got %q, want %q", tt.loc, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/txtar"
+)
+
+// TestScripts runs the scripts of testdata/script, in the style of the
+// go command ones: each is a txtar archive whose files are extracted
+// to a temporary directory, $WORK, and whose comment is run in it, a
+// command per line. The commands are
+//
+//	sloppy-netparser ARGS  run the command, that must exit with status 0
+//	stdin FILE             use FILE as the standard input of the next run
+//	status N               the last run exited with status N
+//	stdout REGEXP          the standard output of the last run matches REGEXP
+//	stderr REGEXP          the standard error of the last run matches REGEXP
+//	cmp FILE1 FILE2        the files have the same content; FILE1 may be
+//	                       stdout or stderr, for the output of the last run
+//
+// A ! before a command negates it: the run must exit with a status
+// other than 0, or the output not match. The arguments may be quoted
+// with single quotes, and $WORK is replaced by the directory.
+func TestScripts(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the scripts expect slash separated paths")
+	}
+	scripts, err := filepath.Glob(filepath.Join("testdata", "script", "*.txtar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) == 0 {
+		t.Fatal("no scripts")
+	}
+	for _, script := range scripts {
+		script := script
+		t.Run(strings.TrimSuffix(filepath.Base(script), ".txtar"), func(t *testing.T) {
+			t.Parallel()
+			runScript(t, script)
+		})
+	}
+}
+
+// scriptState is the state of a running script.
+type scriptState struct {
+	work           string
+	stdin          string
+	stdout, stderr string
+	status         int
+}
+
+func runScript(t *testing.T, script string) {
+	a, err := txtar.ParseFile(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &scriptState{work: t.TempDir()}
+	for _, f := range a.Files {
+		path := filepath.Join(s.work, filepath.FromSlash(f.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, f.Data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, line := range strings.Split(string(a.Comment), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		neg := strings.HasPrefix(line, "!")
+		line = strings.TrimSpace(strings.TrimPrefix(line, "!"))
+		args, err := splitScriptLine(strings.ReplaceAll(line, "$WORK", s.work))
+		if err == nil {
+			err = s.run(t, args, neg)
+		}
+		if err != nil {
+			t.Fatalf("%s:%d: %s: %v\nstdout:\n%s\nstderr:\n%s", script, i+1, line, err, s.stdout, s.stderr)
+		}
+	}
+}
+
+// run runs the command args, negated if neg is set.
+func (s *scriptState) run(t *testing.T, args []string, neg bool) error {
+	switch cmd := args[0]; cmd {
+	case "sloppy-netparser":
+		s.stdout, s.stderr, s.status = runMainIn(t, s.work, s.stdin, args[1:]...)
+		s.stdin = ""
+		if neg != (s.status != 0) {
+			return fmt.Errorf("unexpected exit status %d", s.status)
+		}
+	case "stdin":
+		if len(args) != 2 || neg {
+			return fmt.Errorf("usage: stdin FILE")
+		}
+		b, err := os.ReadFile(filepath.Join(s.work, args[1]))
+		if err != nil {
+			return err
+		}
+		s.stdin = string(b)
+	case "status":
+		if len(args) != 2 || neg {
+			return fmt.Errorf("usage: status N")
+		}
+		if want, err := strconv.Atoi(args[1]); err != nil || s.status != want {
+			return fmt.Errorf("exit status %d, want %s", s.status, args[1])
+		}
+	case "stdout", "stderr":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: %s REGEXP", cmd)
+		}
+		re, err := regexp.Compile("(?m)" + args[1])
+		if err != nil {
+			return err
+		}
+		out := s.stdout
+		if cmd == "stderr" {
+			out = s.stderr
+		}
+		if re.MatchString(out) == neg {
+			return fmt.Errorf("%s match: %v, want %v", cmd, !neg, neg)
+		}
+	case "cmp":
+		if len(args) != 3 || neg {
+			return fmt.Errorf("usage: cmp FILE1 FILE2")
+		}
+		var got string
+		switch args[1] {
+		case "stdout":
+			got = s.stdout
+		case "stderr":
+			got = s.stderr
+		default:
+			b, err := os.ReadFile(filepath.Join(s.work, args[1]))
+			if err != nil {
+				return err
+			}
+			got = string(b)
+		}
+		want, err := os.ReadFile(filepath.Join(s.work, args[2]))
+		if err != nil {
+			return err
+		}
+		if got != string(want) {
+			return fmt.Errorf("%s and %s differ:\n--- %s\n%s\n--- %s\n%s", args[1], args[2], args[1], got, args[2], want)
+		}
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+	return nil
+}
+
+// splitScriptLine splits line into its space separated arguments,
+// some of them quoted with single quotes.
+func splitScriptLine(line string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg, quoted := false, false
+	for _, r := range line {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+			inArg = true
+		case r == ' ' && !quoted:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
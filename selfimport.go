@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// selfRules returns rules with the rules whose target is the package
+// of dir marked, so the files of the package do not import it: the
+// references become the bare TargetName if the package declares it,
+// and are left alone, with a warning, otherwise. This is the case of
+// the rules applied to k8s.io/utils/net itself, or a fork of it.
+func selfRules(dir string, rules []Rule) []Rule {
+	pkgPath := dirImportPath(dir)
+	if pkgPath == "" {
+		return rules
+	}
+	var out []Rule
+	var declared map[string]bool
+	for i, r := range rules {
+		if r.TargetPath != pkgPath {
+			continue
+		}
+		if out == nil {
+			out = append([]Rule(nil), rules...)
+			declared = packageFuncs(dir)
+		}
+		if declared[r.TargetName] {
+			out[i].samePackage = true
+		} else {
+			out[i].selfImport = true
+		}
+	}
+	if out == nil {
+		return rules
+	}
+	return out
+}
+
+// dirImportPath returns the import path of the package in dir, from
+// the go.mod file of its module, or "" if it is not in a module.
+func dirImportPath(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for d := dir; ; {
+		if data, err := os.ReadFile(filepath.Join(d, "go.mod")); err == nil {
+			mod := modulePath(data)
+			if mod == "" {
+				return ""
+			}
+			rel, err := filepath.Rel(d, dir)
+			if err != nil {
+				return ""
+			}
+			return path.Join(mod, filepath.ToSlash(rel))
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return ""
+		}
+		d = parent
+	}
+}
+
+// modulePath returns the module path declared by the go.mod file
+// content data, or "" if there is none.
+func modulePath(data []byte) string {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "module" {
+			continue
+		}
+		if mod, err := strconv.Unquote(fields[1]); err == nil {
+			return mod
+		}
+		return fields[1]
+	}
+	return ""
+}
+
+// packageFuncs returns the names of the functions declared by the
+// non-test Go files of dir.
+func packageFuncs(dir string) map[string]bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	funcs := make(map[string]bool)
+	fset := token.NewFileSet()
+	for _, e := range entries {
+		if !isGoFile(e) || strings.HasSuffix(e.Name(), "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, e.Name()), nil, 0)
+		if err != nil {
+			continue
+		}
+		for _, d := range f.Decls {
+			if fd, ok := d.(*ast.FuncDecl); ok && fd.Recv == nil {
+				funcs[fd.Name.Name] = true
+			}
+		}
+	}
+	return funcs
+}
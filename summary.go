@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// pkgSummary is the state of the migration of a package, a directory,
+// once the rewritten files are applied.
+type pkgSummary struct {
+	// remaining counts the references to the strict parsers left
+	// in each file: skipped by //sloppy:ignore or excluded by -tags.
+	remaining map[string]int
+	// names holds the names the targets of the rules are imported as.
+	names map[string]map[string]bool
+}
+
+// summaries holds the summary of each package, for -summary.
+var summaries = make(map[string]*pkgSummary)
+
+// summarize records the result res of a file, rewritten to out, in the
+// summary of its package. The generated and the non-Go files are not
+// counted.
+func summarize(res Result, out []byte) {
+	if res.Generated || res.NotGo {
+		return
+	}
+	dir := filepath.Dir(res.Filename)
+	s := summaries[dir]
+	if s == nil {
+		s = &pkgSummary{remaining: make(map[string]int), names: make(map[string]map[string]bool)}
+		summaries[dir] = s
+	}
+
+	n := len(res.Ignored)
+	if res.Excluded {
+		for _, c := range res.Calls {
+			n += c
+		}
+	}
+	if n > 0 {
+		s.remaining[res.Filename] = n
+	}
+
+	f, err := parser.ParseFile(token.NewFileSet(), res.Filename, out, parser.ImportsOnly)
+	if err != nil {
+		return
+	}
+	for _, r := range sloppyRules {
+		for _, spec := range importSpecs(f, r.TargetPath) {
+			if s.names[r.TargetPath] == nil {
+				s.names[r.TargetPath] = make(map[string]bool)
+			}
+			s.names[r.TargetPath][importName(spec)] = true
+		}
+	}
+}
+
+// printSummary prints the summary of each package to w, sorted by
+// directory, like
+//
+//	pkg/a: fully migrated
+//	pkg/b: 2 files still using the strict parsers
+//	pkg/b: k8s.io/utils/net imported as netutils, utilnet
+func printSummary(w io.Writer) {
+	dirs := make([]string, 0, len(summaries))
+	for dir := range summaries {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		s := summaries[dir]
+		switch n := len(s.remaining); n {
+		case 0:
+			fmt.Fprintf(w, "%s: fully migrated\n", dir)
+		case 1:
+			fmt.Fprintf(w, "%s: 1 file still using the strict parsers\n", dir)
+		default:
+			fmt.Fprintf(w, "%s: %d files still using the strict parsers\n", dir, n)
+		}
+		paths := make([]string, 0, len(s.names))
+		for path := range s.names {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			if len(s.names[path]) < 2 {
+				continue
+			}
+			names := make([]string, 0, len(s.names[path]))
+			for name := range s.names[path] {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			fmt.Fprintf(w, "%s: %s imported as %s\n", dir, path, strings.Join(names, ", "))
+		}
+	}
+}
+
+// printDrySummary prints to w the changes of results, as a whole, like
+//
+//	3 files would be rewritten
+//	3 files would gain the k8s.io/utils/net import
+//	2 files would lose the net import
+//	1 ParseCIDR and 4 ParseIP calls would be rewritten
+//
+// The imports are sorted by path and the calls by name.
+func printDrySummary(w io.Writer, results []Result) {
+	added := make(map[string]int)
+	removed := make(map[string]int)
+	calls := make(map[string]int)
+	for _, res := range results {
+		for _, path := range res.ImportsAdded {
+			added[path]++
+		}
+		for _, path := range res.ImportsRemoved {
+			removed[path]++
+		}
+		for name, n := range res.Calls {
+			calls[name] += n
+		}
+	}
+
+	fmt.Fprintf(w, "%s would be rewritten\n", plural(len(results), "file"))
+	for _, path := range sortedKeys(added) {
+		fmt.Fprintf(w, "%s would gain the %s import\n", plural(added[path], "file"), path)
+	}
+	for _, path := range sortedKeys(removed) {
+		fmt.Fprintf(w, "%s would lose the %s import\n", plural(removed[path], "file"), path)
+	}
+	if len(calls) > 0 {
+		fmt.Fprintf(w, "%s would be rewritten\n", callCounts(calls))
+	}
+}
+
+// printTotals prints to w the number of files of results, and of the
+// calls rewritten, on one line, like
+//
+//	3 files rewritten, 1 ParseCIDR and 4 ParseIP calls
+//
+// If not written, the files are reported as would be rewritten.
+func printTotals(w io.Writer, results []Result, written bool) {
+	calls := make(map[string]int)
+	for _, res := range results {
+		for name, n := range res.Calls {
+			calls[name] += n
+		}
+	}
+	verb := "rewritten"
+	if !written {
+		verb = "would be rewritten"
+	}
+	line := fmt.Sprintf("%s %s", plural(len(results), "file"), verb)
+	if len(calls) > 0 {
+		line += ", " + callCounts(calls)
+	}
+	fmt.Fprintln(w, line)
+}
+
+// callCounts returns the number of calls of each name in calls, sorted
+// by name, like "1 ParseCIDR and 4 ParseIP calls".
+func callCounts(calls map[string]int) string {
+	var parts []string
+	total := 0
+	for _, name := range sortedKeys(calls) {
+		parts = append(parts, fmt.Sprintf("%d %s", calls[name], name))
+		total += calls[name]
+	}
+	noun := "calls"
+	if total == 1 {
+		noun = "call"
+	}
+	return strings.Join(parts, " and ") + " " + noun
+}
+
+// plural returns n and noun, in the plural if n is not 1.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// sortedKeys returns the keys of m, sorted.
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
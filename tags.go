@@ -0,0 +1,78 @@
+package main
+
+import (
+	"go/build"
+	"path/filepath"
+	"strings"
+)
+
+// knownOS and knownArch are the values of GOOS and GOARCH that a
+// -tags list can select.
+var (
+	knownOS = map[string]bool{
+		"aix": true, "android": true, "darwin": true, "dragonfly": true,
+		"freebsd": true, "hurd": true, "illumos": true, "ios": true,
+		"js": true, "linux": true, "netbsd": true, "openbsd": true,
+		"plan9": true, "solaris": true, "wasip1": true, "windows": true,
+		"zos": true,
+	}
+	knownArch = map[string]bool{
+		"386": true, "amd64": true, "arm": true, "arm64": true,
+		"loong64": true, "mips": true, "mips64": true, "mips64le": true,
+		"mipsle": true, "ppc64": true, "ppc64le": true, "riscv64": true,
+		"s390x": true, "wasm": true,
+	}
+)
+
+// buildContext returns the build context selected by tags, a comma
+// separated list of build tags like "linux,!cgo". Operating systems
+// and architectures set GOOS and GOARCH, negated ones clear them if
+// they are the host ones, cgo and !cgo toggle cgo, other tags are added to the build tags. It returns nil if tags is
+// empty, meaning every file is active.
+func buildContext(tags string) *build.Context {
+	if tags == "" {
+		return nil
+	}
+	ctx := build.Default
+	ctx.BuildTags = nil
+	for _, tag := range strings.Split(tags, ",") {
+		tag = strings.TrimSpace(tag)
+		switch {
+		case tag == "":
+		case tag == "cgo":
+			ctx.CgoEnabled = true
+		case tag == "!cgo":
+			ctx.CgoEnabled = false
+		case knownOS[tag]:
+			ctx.GOOS = tag
+		case knownArch[tag]:
+			ctx.GOARCH = tag
+		case strings.HasPrefix(tag, "!"):
+			// Tags are unset unless listed, but GOOS and GOARCH
+			// default to the host ones: a negated one is cleared,
+			// matching none of its files.
+			switch name := tag[1:]; {
+			case knownOS[name] && ctx.GOOS == name:
+				ctx.GOOS = ""
+			case knownArch[name] && ctx.GOARCH == name:
+				ctx.GOARCH = ""
+			}
+		default:
+			ctx.BuildTags = append(ctx.BuildTags, tag)
+		}
+	}
+	return &ctx
+}
+
+// activeFile reports whether the file at path is part of the build
+// selected by ctx. Every file is active if ctx is nil.
+func activeFile(ctx *build.Context, path string) (bool, error) {
+	if ctx == nil {
+		return true, nil
+	}
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	return ctx.MatchFile(dir, name)
+}
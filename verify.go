@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// pending holds the rewritten source of the files, by absolute path,
+// that -fix-and-verify-compile writes once their package compiles.
+var pending = make(map[string][]byte)
+
+// writeVerified type-checks the packages of files with their rewritten
+// source, and writes the files of the packages that still compile.
+// The files of the other packages are left untouched, unless
+// keepBroken is set, and the type errors returned.
+func writeVerified(files map[string][]byte, keepBroken bool) error {
+	byDir := make(map[string][]string)
+	for path := range files {
+		dir := filepath.Dir(path)
+		byDir[dir] = append(byDir[dir], path)
+	}
+	var dirs []string
+	for dir := range byDir {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	var errs errorList
+	for _, dir := range dirs {
+		if err := typeCheck(dir, files); err != nil {
+			if !keepBroken {
+				errs = append(errs, fmt.Errorf("%s: not rewritten, the package would not compile:\n%v", dir, err))
+				continue
+			}
+			errs = append(errs, fmt.Errorf("%s: rewritten, but the package does not compile:\n%v", dir, err))
+		}
+		paths := byDir[dir]
+		sort.Strings(paths)
+		for _, path := range paths {
+			if err := writeFile(path, files[path]); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs.err()
+}
+
+// typeCheck type-checks the package in dir, with its tests, replacing
+// the files in overlay by their content, and returns its errors.
+// go/packages only lists the files: the packages are type-checked
+// from source, so the result does not depend on the export data
+// format of the toolchain. The files excluded from the build, like
+// the ones guarded by a goexperiment tag not set in GOEXPERIMENT,
+// are not checked.
+func typeCheck(dir string, overlay map[string][]byte) error {
+	cfg := &packages.Config{
+		Mode:  packages.NeedName | packages.NeedFiles,
+		Dir:   dir,
+		Tests: true,
+	}
+	pkgs, err := packages.Load(cfg, ".")
+	if err != nil {
+		return err
+	}
+
+	// The source importer resolves the imports with go list, run in
+	// build.Default.Dir: run it in the module of the package.
+	defer func(wd string) { build.Default.Dir = wd }(build.Default.Dir)
+	build.Default.Dir = dir
+
+	fset := token.NewFileSet()
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Sizes:    types.SizesFor("gc", build.Default.GOARCH),
+	}
+	// A package is loaded once per test variant, so report each
+	// error once.
+	var errs errorList
+	seen := make(map[string]bool)
+	add := func(err error) {
+		if !seen[err.Error()] {
+			seen[err.Error()] = true
+			errs = append(errs, err)
+		}
+	}
+	conf.Error = add
+	for _, p := range pkgs {
+		// Skip the generated test main package.
+		if strings.HasSuffix(p.ID, ".test") {
+			continue
+		}
+		for _, e := range p.Errors {
+			add(e)
+		}
+		var files []*ast.File
+		for _, path := range p.GoFiles {
+			src, ok := overlay[path]
+			if !ok {
+				if src, err = os.ReadFile(path); err != nil {
+					return err
+				}
+			}
+			f, err := parser.ParseFile(fset, path, src, parserMode)
+			if err != nil {
+				return err
+			}
+			files = append(files, f)
+		}
+		conf.Check(p.PkgPath, fset, files, nil)
+	}
+	return errs.err()
+}
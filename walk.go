@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config configures WalkAndFix.
+type Config struct {
+	// Tags is a comma separated list of build tags, like "linux,!cgo".
+	// If set, the files that do not match it are still rewritten,
+	// but reported with Result.Excluded set.
+	Tags string
+	// IncludeGenerated rewrites the generated files too. Otherwise
+	// they are reported with Result.Generated set, and not rewritten.
+	IncludeGenerated bool
+	// TrimPath is stripped from the file names in the results.
+	TrimPath string
+	// Rules are the rewrites to apply, sloppyRules if nil.
+	Rules []Rule
+	// MaxErrors stops the walk after that many errors, if positive.
+	MaxErrors int
+	// FollowSymlinks walks the symlinks to directories too. The files
+	// are then told apart by their real path.
+	FollowSymlinks bool
+	// ExcludeDirs are the names of the directories to skip when
+	// walking the trees, like vendor. The paths given are walked,
+	// whatever their name.
+	ExcludeDirs []string
+	// ReadAhead reads up to that many files ahead of the one being
+	// processed, concurrently, if positive. The files are still
+	// processed one at a time, in order.
+	ReadAhead int
+	// IgnoreFiles are the paths of files never processed, even if
+	// given in the paths: fn is not called for them.
+	IgnoreFiles []string
+}
+
+// WalkAndFix rewrites the Go files in paths, walking the directories,
+// and calls fn for each of them with its result and rewritten source.
+// The files are not modified, fn decides what to do with the result.
+// Without renameExistingAlias, the new imports use the names the other
+// files of the package already import their path as, if any.
+// A file reached through several paths is processed once. The files
+// given that do not end in .go are not parsed: fn gets them with
+// Result.NotGo set. A package importing a rewritten package under
+// several names is reported once, in the warnings of one of its files.
+// WalkAndFix does not stop at the errors, returned by fn or otherwise,
+// unless Config.MaxErrors is reached: it processes all the files and
+// returns them together, as an error with an Unwrap() []error method,
+// like the errors.Join ones.
+func WalkAndFix(paths []string, cfg Config, fn func(path string, res Result, out []byte) error) error {
+	return WalkAndFixContext(context.Background(), paths, cfg, fn)
+}
+
+// WalkAndFixContext is WalkAndFix, stopping when ctx is done: the file
+// in progress is abandoned, and the errors report it as incomplete and
+// each of the files left as not processed. Its rewrite may still
+// complete in the background, but fn is not called with the result:
+// fn is only called by WalkAndFixContext itself, before it returns.
+// fn is not interrupted.
+func WalkAndFixContext(ctx context.Context, paths []string, cfg Config, fn func(path string, res Result, out []byte) error) error {
+	bctx := buildContext(cfg.Tags)
+	rules := cfg.Rules
+	if rules == nil {
+		rules = sloppyRules
+	}
+	seen := make(map[string]bool)
+	ignore := cfg.ignored()
+	// dirRules caches the rules of each directory, see selfRules, and
+	// dirAliases its named imports, see packageRules.
+	dirRules := make(map[string][]Rule)
+	dirAliases := make(map[string]importAliases)
+	// dirWarnings holds the warnings of each directory, see
+	// importNameWarnings, until given with the result of one of its
+	// files.
+	dirWarnings := make(map[string][]string)
+	var sourcePaths []string
+	for _, r := range rules {
+		sourcePaths = append(sourcePaths, r.Path)
+	}
+	read := readFile
+	if cfg.ReadAhead > 0 {
+		ra := startReadAhead(paths, cfg, cfg.ReadAhead)
+		defer ra.close()
+		read = ra.read
+	}
+
+	process := func(path, abs string) error {
+		active, err := activeFile(bctx, path)
+		if err != nil {
+			return err
+		}
+		src, err := read(path)
+		if err != nil {
+			return err
+		}
+		name := trimPath(path, cfg.TrimPath)
+		if !cfg.IncludeGenerated && isGenerated(src) {
+			return fn(path, Result{Filename: name, Generated: true}, src)
+		}
+		dir := filepath.Dir(abs)
+		if _, ok := dirRules[dir]; !ok {
+			dirRules[dir] = selfRules(dir, rules)
+			dirAliases[dir] = packageAliases(dir)
+			dirWarnings[dir] = importNameWarnings(dir, trimPath(filepath.Dir(path), cfg.TrimPath), sourcePaths)
+		}
+		out, res, err := rewriteContext(ctx, src, name, packageRules(dirAliases[dir], filepath.Base(abs), dirRules[dir]))
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s: incomplete: %w", path, ctx.Err())
+		}
+		if err != nil {
+			return err
+		}
+		res.Warnings = append(dirWarnings[dir], res.Warnings...)
+		dirWarnings[dir] = nil
+		res.Excluded = !active
+		return fn(path, res, out)
+	}
+	visit := func(path string) error {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		key := abs
+		if cfg.FollowSymlinks {
+			if real, err := filepath.EvalSymlinks(abs); err == nil {
+				key = real
+			}
+		}
+		if seen[key] || ignore[key] {
+			return nil
+		}
+		seen[key] = true
+
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("%s: not processed: %w", path, err)
+		}
+		return process(path, abs)
+	}
+
+	var errs errorList
+	// add records err, and reports whether the walk must stop.
+	add := func(err error) bool {
+		errs = append(errs, err)
+		if cfg.MaxErrors > 0 && len(errs) >= cfg.MaxErrors {
+			errs = append(errs, ErrTooManyErrors)
+			return true
+		}
+		return false
+	}
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			if add(err) {
+				break
+			}
+			continue
+		}
+		if !fi.IsDir() {
+			if !strings.HasSuffix(fi.Name(), ".go") {
+				// Not parsed: the templates, like foo.go.tmpl or
+				// foo.gotmpl, are not valid Go.
+				err = fn(path, Result{Filename: trimPath(path, cfg.TrimPath), NotGo: true}, nil)
+			} else {
+				err = visit(path)
+			}
+			if err != nil && add(err) {
+				break
+			}
+			continue
+		}
+		root := path
+		err = walkDir(path, cfg.FollowSymlinks, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.IsDir() && path != root && cfg.excluded(path) {
+				return filepath.SkipDir
+			}
+			if err == nil && isGoFile(d) {
+				err = visit(path)
+			}
+			if err != nil && add(err) {
+				return ErrTooManyErrors
+			}
+			return nil
+		})
+		if err == ErrTooManyErrors {
+			break
+		}
+		if err != nil && add(err) {
+			break
+		}
+	}
+	return errs.err()
+}
+
+// rewriteContext is rewrite, returning when ctx is done: the rewrite
+// runs in a goroutine, so a hung import fixer cannot block the walk,
+// and its result is dropped if it completes later.
+func rewriteContext(ctx context.Context, src []byte, name string, rules []Rule) ([]byte, Result, error) {
+	if ctx.Done() == nil {
+		return rewrite(src, name, rules)
+	}
+	type rewritten struct {
+		out []byte
+		res Result
+		err error
+	}
+	done := make(chan rewritten, 1)
+	go func() {
+		out, res, err := rewrite(src, name, rules)
+		done <- rewritten{out, res, err}
+	}()
+	select {
+	case r := <-done:
+		return r.out, r.res, r.err
+	case <-ctx.Done():
+		return nil, Result{Filename: name}, ctx.Err()
+	}
+}
+
+// walkFiles calls fn with the files of paths, walking the directories,
+// in the order WalkAndFix finds them with cfg, until fn returns false.
+// The errors are ignored, and a file reached through several paths is
+// reported each time.
+func walkFiles(paths []string, cfg Config, fn func(path string) bool) {
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if !fi.IsDir() {
+			if !fn(path) {
+				return
+			}
+			continue
+		}
+		root := path
+		err = walkDir(path, cfg.FollowSymlinks, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.IsDir() && path != root && cfg.excluded(path) {
+				return filepath.SkipDir
+			}
+			if err == nil && isGoFile(d) && !fn(path) {
+				return errStopWalk
+			}
+			return nil
+		})
+		if err == errStopWalk {
+			return
+		}
+	}
+}
+
+// errStopWalk stops the walk of walkFiles.
+var errStopWalk = errors.New("stop walk")
+
+// ignored returns the set of the absolute paths of IgnoreFiles, and of
+// their real paths with FollowSymlinks, like the keys of the files
+// WalkAndFix processes.
+func (cfg Config) ignored() map[string]bool {
+	ignore := make(map[string]bool)
+	for _, path := range cfg.IgnoreFiles {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			continue
+		}
+		ignore[abs] = true
+		if cfg.FollowSymlinks {
+			if real, err := filepath.EvalSymlinks(abs); err == nil {
+				ignore[real] = true
+			}
+		}
+	}
+	return ignore
+}
+
+// excluded reports whether the directory dir is one of ExcludeDirs.
+func (cfg Config) excluded(dir string) bool {
+	name := filepath.Base(dir)
+	for _, x := range cfg.ExcludeDirs {
+		if name == x {
+			return true
+		}
+	}
+	return false
+}
+
+// walkDir is filepath.WalkDir, following the symlinks to directories
+// if follow is set: their files are reported under the path of the
+// symlink. Each directory is walked once, so the symlink loops end.
+func walkDir(root string, follow bool, fn fs.WalkDirFunc) error {
+	if !follow {
+		return filepath.WalkDir(root, fn)
+	}
+	visited := make(map[string]bool)
+	// walk walks the directory real, reported as root.
+	var walk func(root, real string) error
+	walk = func(root, real string) error {
+		return filepath.WalkDir(real, func(path string, d fs.DirEntry, err error) error {
+			realPath := path
+			if rel, relErr := filepath.Rel(real, path); relErr == nil {
+				path = filepath.Join(root, rel)
+			}
+			if err != nil {
+				return fn(path, d, err)
+			}
+			if d.IsDir() {
+				if visited[realPath] {
+					return filepath.SkipDir
+				}
+				visited[realPath] = true
+			}
+			if d.Type()&fs.ModeSymlink == 0 {
+				return fn(path, d, nil)
+			}
+			target, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				return fn(path, d, err)
+			}
+			fi, err := os.Stat(target)
+			if err != nil {
+				return fn(path, d, err)
+			}
+			if !fi.IsDir() {
+				return fn(path, d, nil)
+			}
+			if visited[target] {
+				return nil
+			}
+			return walk(path, target)
+		})
+	}
+	real, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	return walk(root, real)
+}
+
+// ErrTooManyErrors ends the errors of a WalkAndFix run stopped
+// after Config.MaxErrors errors.
+var ErrTooManyErrors = errors.New("too many errors, stopping")
+
+// errorList is a list of errors, printed one per line.
+// It is errors.Join, not available with go 1.16.
+type errorList []error
+
+func (l errorList) Error() string {
+	s := make([]string, len(l))
+	for i, err := range l {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+// Unwrap returns the errors of the list, for errors.Is and errors.As.
+func (l errorList) Unwrap() []error {
+	return l
+}
+
+// err returns nil if the list is empty, l otherwise.
+func (l errorList) err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
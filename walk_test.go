@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWalkAndFix(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.go":          dirtySrc,
+		"clean.go":      cleanSrc,
+		"sub/b.go":      dirtySrc,
+		"sub/b_test.go": dirtySrc,
+		"sub/gen.go":    "// Code generated by gen. DO NOT EDIT.\n\n" + dirtySrc,
+		"sub/notgo.md":  dirtySrc,
+	})
+
+	// sink records the callback invocations.
+	sink := make(map[string]string)
+	err := WalkAndFix([]string{dir, filepath.Join(dir, "a.go")}, Config{TrimPath: dir}, func(path string, res Result, out []byte) error {
+		var state string
+		switch {
+		case res.Generated:
+			state = "generated"
+		case res.Changed():
+			state = res.String()
+			if !strings.Contains(string(out), "netutils.ParseIPSloppy") {
+				t.Errorf("%s: output not rewritten:\n%s", path, out)
+			}
+		default:
+			state = "unchanged"
+		}
+		if _, ok := sink[path]; ok {
+			t.Errorf("%s: processed twice", path)
+		}
+		sink[path] = state
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"a.go":          "a.go: +1 ParseIP, +netutils",
+		"clean.go":      "unchanged",
+		"sub/b.go":      filepath.Join("sub", "b.go") + ": +1 ParseIP, +netutils",
+		"sub/b_test.go": filepath.Join("sub", "b_test.go") + ": +1 ParseIP, +netutils",
+		"sub/gen.go":    "generated",
+	}
+	var got []string
+	for path := range sink {
+		got = append(got, path)
+	}
+	sort.Strings(got)
+	if len(got) != len(want) {
+		t.Errorf("processed %v, want %d files", got, len(want))
+	}
+	for name, state := range want {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if sink[path] != state {
+			t.Errorf("%s: got %q, want %q", name, sink[path], state)
+		}
+	}
+}
+
+func TestWalkAndFixKeepsGoing(t *testing.T) {
+	broken := "package main\n\nfunc f() { net.ParseIP( }\n"
+	dir := writeFiles(t, map[string]string{"a.go": broken, "b.go": dirtySrc})
+
+	var fixed []string
+	err := WalkAndFix([]string{filepath.Join(dir, "missing.go"), dir}, Config{}, func(path string, res Result, out []byte) error {
+		fixed = append(fixed, filepath.Base(path))
+		return errors.New("sink failed")
+	})
+	if err == nil || !strings.Contains(err.Error(), "missing.go") {
+		t.Errorf("got error %v, want one about missing.go", err)
+	}
+	if len(fixed) != 1 || fixed[0] != "b.go" {
+		t.Errorf("processed %v, want [b.go]", fixed)
+	}
+}
+
+func TestWalkAndFixErrors(t *testing.T) {
+	broken := "package main\n\nfunc f() { net.ParseIP( }\n"
+	dir := writeFiles(t, map[string]string{
+		"a.go":     broken,
+		"b.go":     dirtySrc,
+		"sub/c.go": broken,
+	})
+
+	var fixed []string
+	err := WalkAndFix([]string{dir, filepath.Join(dir, "missing.go")}, Config{}, func(path string, res Result, out []byte) error {
+		fixed = append(fixed, filepath.Base(path))
+		return nil
+	})
+	if !reflect.DeepEqual(fixed, []string{"b.go"}) {
+		t.Errorf("processed %v, want [b.go]", fixed)
+	}
+	u, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("got error %v, want a list of errors", err)
+	}
+	errs := u.Unwrap()
+	if len(errs) != 3 {
+		t.Fatalf("got %d errors, want 3:\n%v", len(errs), err)
+	}
+	for i, name := range []string{"a.go", filepath.Join("sub", "c.go"), "missing.go"} {
+		if !strings.Contains(errs[i].Error(), filepath.Join(dir, name)) {
+			t.Errorf("error %d %q does not refer to %s", i, errs[i], name)
+		}
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("errors.Is(%v, fs.ErrNotExist) = false", err)
+	}
+}
+
+func TestWalkAndFixPackageAlias(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.go": dirtySrc,
+		"b.go": `package main
+
+import (
+	"net"
+
+	utilnet "k8s.io/utils/net"
+)
+
+var ip = utilnet.ParseIPSloppy("1.2.3.4")
+
+var ip2 = net.ParseIP("1.2.3.4")
+`,
+		"other/c.go": dirtySrc,
+	})
+
+	walk := func() map[string]string {
+		t.Helper()
+		out := make(map[string]string)
+		err := WalkAndFix([]string{dir}, Config{TrimPath: dir}, func(path string, res Result, b []byte) error {
+			out[res.Filename] = string(b)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	// The utilnet import is renamed, and the package converges on netutils.
+	out := walk()
+	for _, name := range []string{"a.go", "b.go", filepath.Join("other", "c.go")} {
+		if f := out[name]; !strings.Contains(f, `netutils "k8s.io/utils/net"`) || strings.Contains(f, "utilnet") {
+			t.Errorf("%s does not use netutils:\n%s", name, f)
+		}
+	}
+
+	renameExistingAlias = false
+	out = walk()
+	renameExistingAlias = true
+	if a := out["a.go"]; !strings.Contains(a, `utilnet "k8s.io/utils/net"`) || !strings.Contains(a, "utilnet.ParseIPSloppy") {
+		t.Errorf("a.go does not use the utilnet alias of the package:\n%s", a)
+	}
+	if b := out["b.go"]; !strings.Contains(b, "utilnet.ParseIPSloppy(\"1.2.3.4\")\n\nvar ip2 = utilnet.ParseIPSloppy") {
+		t.Errorf("b.go does not keep its utilnet import:\n%s", b)
+	}
+	if c := out[filepath.Join("other", "c.go")]; !strings.Contains(c, "netutils.ParseIPSloppy") {
+		t.Errorf("other/c.go does not use the default alias:\n%s", c)
+	}
+}
+
+func TestWalkAndFixMaxErrors(t *testing.T) {
+	broken := "package main\n\nfunc f() { net.ParseIP( }\n"
+	dir := writeFiles(t, map[string]string{
+		"a.go": broken,
+		"b.go": broken,
+		"c.go": broken,
+		"d.go": broken,
+	})
+
+	err := WalkAndFix([]string{dir}, Config{MaxErrors: 2}, func(path string, res Result, out []byte) error {
+		return nil
+	})
+	errs := err.(interface{ Unwrap() []error }).Unwrap()
+	if len(errs) != 3 || errs[2] != ErrTooManyErrors {
+		t.Fatalf("got errors %v, want 2 and ErrTooManyErrors", err)
+	}
+	for i, name := range []string{"a.go", "b.go"} {
+		if !strings.Contains(errs[i].Error(), filepath.Join(dir, name)) {
+			t.Errorf("error %d %q does not refer to %s", i, errs[i], name)
+		}
+	}
+}
+
+func TestWalkAndFixContext(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.go": dirtySrc,
+		"b.go": dirtySrc,
+		"c.go": dirtySrc,
+	})
+	// The import fixer of a.go does not return before the deadline,
+	// like a hung imports.Process.
+	unblock := make(chan struct{})
+	returned := make(chan struct{})
+	fixImports = func(filename string, src []byte) ([]byte, error) {
+		defer close(returned)
+		<-unblock
+		return src, nil
+	}
+	defer func() { fixImports = formatImports }()
+
+	var calls int32
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := WalkAndFixContext(ctx, []string{dir}, Config{}, func(path string, res Result, out []byte) error {
+		atomic.AddInt32(&calls, 1)
+		return os.WriteFile(path, out, 0644)
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want context.DeadlineExceeded", err)
+	}
+	errs := err.(interface{ Unwrap() []error }).Unwrap()
+	want := []string{
+		filepath.Join(dir, "a.go") + ": incomplete: context deadline exceeded",
+		filepath.Join(dir, "b.go") + ": not processed: context deadline exceeded",
+		filepath.Join(dir, "c.go") + ": not processed: context deadline exceeded",
+	}
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got errors %q, want %q", got, want)
+	}
+
+	// The abandoned rewrite of a.go completes after the deadline: its
+	// result must be dropped, not written.
+	close(unblock)
+	<-returned
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Errorf("fn called %d times after the deadline", n)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.go")); string(b) != dirtySrc {
+		t.Errorf("a.go written after the deadline:\n%s", b)
+	}
+}
+
+func TestWalkAndFixSymlinks(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"src/a.go":        dirtySrc,
+		"vendor/lib/b.go": dirtySrc,
+	})
+	links := map[string]string{
+		// The file behind a symlinked directory.
+		filepath.Join(dir, "src", "lib"): filepath.Join(dir, "vendor", "lib"),
+		// A loop.
+		filepath.Join(dir, "src", "loop"): filepath.Join(dir, "src"),
+		// A second path to b.go.
+		filepath.Join(dir, "src", "lib2"): filepath.Join("..", "vendor", "lib"),
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("cannot create symlinks: %v", err)
+		}
+	}
+
+	for _, follow := range []bool{false, true} {
+		var got []string
+		root := filepath.Join(dir, "src")
+		err := WalkAndFix([]string{root}, Config{TrimPath: root, FollowSymlinks: follow}, func(path string, res Result, out []byte) error {
+			got = append(got, filepath.ToSlash(res.Filename))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"a.go"}
+		if follow {
+			want = append(want, "lib/b.go")
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("FollowSymlinks=%v: processed %q, want %q", follow, got, want)
+		}
+	}
+}
+
+func TestWalkAndFixReadAhead(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.go":              dirtySrc,
+		"clean.go":          cleanSrc,
+		"sub/b.go":          dirtySrc,
+		"sub/c.go":          "package main\n\nfunc f() { net.ParseIP( }\n",
+		"vendor/d.go":       dirtySrc,
+		"sub/e.go.tmpl":     dirtySrc,
+		"sub/sub/f.go":      dirtySrc,
+		"sub/sub/g_test.go": cleanSrc,
+	})
+	paths := []string{
+		filepath.Join(dir, "sub"),
+		filepath.Join(dir, "missing.go"),
+		filepath.Join(dir, "sub", "e.go.tmpl"),
+		dir,
+	}
+	walk := func(n int) (map[string]string, error) {
+		outs := make(map[string]string)
+		err := WalkAndFix(paths, Config{ExcludeDirs: []string{"vendor"}, ReadAhead: n}, func(path string, res Result, out []byte) error {
+			outs[path] = res.String() + "\n" + string(out)
+			return nil
+		})
+		return outs, err
+	}
+	want, wantErr := walk(0)
+	if len(want) != 6 || wantErr == nil {
+		t.Fatalf("processed %d files, error %v, want 6 files and an error", len(want), wantErr)
+	}
+	for _, n := range []int{1, 3, 100} {
+		got, err := walk(n)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ReadAhead %d: processed\n%v\nwant\n%v", n, got, want)
+		}
+		if err == nil || err.Error() != wantErr.Error() {
+			t.Errorf("ReadAhead %d: error %v, want %v", n, err, wantErr)
+		}
+	}
+}
+
+// BenchmarkWalkAndFixReadAhead walks a tree read from a slow storage,
+// simulated by a delay of a millisecond per read.
+func BenchmarkWalkAndFixReadAhead(b *testing.B) {
+	dir := b.TempDir()
+	for i, src := range benchmarkTree() {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%d.go", i)), src, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	readFile = func(name string) ([]byte, error) {
+		time.Sleep(time.Millisecond)
+		return os.ReadFile(name)
+	}
+	defer func() { readFile = os.ReadFile }()
+
+	for _, n := range []int{0, 4, 16} {
+		b.Run(fmt.Sprintf("read-ahead=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				err := WalkAndFix([]string{dir}, Config{ReadAhead: n}, func(string, Result, []byte) error { return nil })
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestWalkAndFixSelfImport(t *testing.T) {
+	src := `package net
+
+import "net"
+
+func parse(s string) net.IP {
+	return net.ParseIP(s)
+}
+`
+	dir := writeFiles(t, map[string]string{
+		"go.mod": "module k8s.io/utils\n\ngo 1.16\n",
+		"net/ipnet.go": `package net
+
+import "net"
+
+func ParseIPSloppy(s string) net.IP { return nil }
+`,
+		"net/a.go": src,
+		"net/a_test.go": `package net_test
+
+import "net"
+
+var ip = net.ParseIP("1.2.3.4")
+`,
+		"fork/net/go.mod": "module k8s.io/utils/net\n",
+		"fork/net/a.go":   src,
+	})
+	want := map[string]string{
+		"net/a.go": `package net
+
+import "net"
+
+func parse(s string) net.IP {
+	return ParseIPSloppy(s)
+}
+`,
+		"net/a_test.go": `package net_test
+
+import (
+	netutils "k8s.io/utils/net"
+)
+
+var ip = netutils.ParseIPSloppy("1.2.3.4")
+`,
+		"fork/net/a.go": src,
+	}
+	for _, nf := range []bool{false, true} {
+		noFormat = nf
+		got := make(map[string]string)
+		var warnings []string
+		err := WalkAndFix([]string{dir}, Config{TrimPath: dir}, func(path string, res Result, out []byte) error {
+			rel, _ := filepath.Rel(dir, path)
+			got[filepath.ToSlash(rel)] = string(out)
+			warnings = append(warnings, res.Warnings...)
+			return nil
+		})
+		noFormat = false
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, w := range want {
+			if got[name] != w {
+				t.Errorf("noFormat=%v: %s:\n--- have\n%s\n--- want\n%s", nf, name, got[name], w)
+			}
+		}
+		wantWarning := filepath.Join("fork", "net", "a.go") + ":6:9: ParseIP not rewritten, the file is in k8s.io/utils/net: importing it would be a cycle"
+		if len(warnings) != 1 || warnings[0] != wantWarning {
+			t.Errorf("noFormat=%v: warnings %q, want %q", nf, warnings, wantWarning)
+		}
+	}
+}
+
+func TestWalkAndFixImportNames(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"pkg/foo_linux.go": `package pkg
+
+import "net"
+
+var ip = net.ParseIP("1.2.3.4")
+`,
+		"pkg/foo_windows.go": `package pkg
+
+import n "net"
+
+var ip = n.ParseIP("1.2.3.4")
+`,
+		"pkg/foo_test.go": "package pkg_test\n\nimport \"net\"\n\nvar _ net.IP\n",
+	})
+	want := `package pkg
+
+import (
+	netutils "k8s.io/utils/net"
+)
+
+var ip = netutils.ParseIPSloppy("1.2.3.4")
+`
+	var warnings []string
+	err := WalkAndFix([]string{dir}, Config{TrimPath: dir}, func(path string, res Result, out []byte) error {
+		warnings = append(warnings, res.Warnings...)
+		if strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		if string(out) != want {
+			t.Errorf("%s:\n--- have\n%s\n--- want\n%s", path, out, want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantWarnings := []string{`pkg: package pkg imports "net" under several names: n in foo_windows.go; net in foo_linux.go`}
+	if !reflect.DeepEqual(warnings, wantWarnings) {
+		t.Errorf("warnings %q, want %q", warnings, wantWarnings)
+	}
+}